@@ -1,7 +1,12 @@
 package cmd
 
 import (
+	"fmt"
+	"os"
+
+	"github.com/lollipopkit/gommon/log"
 	"github.com/lollipopkit/server_box_monitor/model"
+	"github.com/lollipopkit/server_box_monitor/res"
 	"github.com/urfave/cli/v2"
 )
 
@@ -17,6 +22,26 @@ func init() {
 				Usage:   "Initialize config file",
 				Action:  handleConfInit,
 			},
+			{
+				Name:      "validate",
+				Aliases:   []string{"v"},
+				Usage:     "Check config file, exit with 1 if it's invalid",
+				ArgsUsage: "[path]",
+				Action:    handleConfValidate,
+			},
+			{
+				Name:    "migrate",
+				Aliases: []string{"m"},
+				Usage:   "Show the diff of migrating config file to the current version",
+				Action:  handleConfMigrate,
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:    "write",
+						Aliases: []string{"w"},
+						Usage:   "Write the migrated config, the old one is kept in `.bak`",
+					},
+				},
+			},
 		},
 	})
 }
@@ -24,3 +49,57 @@ func init() {
 func handleConfInit(c *cli.Context) error {
 	return model.InitConfig()
 }
+
+func handleConfValidate(c *cli.Context) error {
+	path := res.AppConfigPath
+	if c.Args().Len() > 0 {
+		path = c.Args().First()
+	}
+	configBytes, err := os.ReadFile(path)
+	if err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+	_, diags := model.DiagnoseConfigFile(configBytes)
+	if len(diags) == 0 {
+		log.Suc("[CONFIG] %s is valid", path)
+		return nil
+	}
+	for _, diag := range diags {
+		fmt.Fprintln(os.Stderr, diag.Error())
+	}
+	return cli.Exit(fmt.Sprintf("%s: %d error(s)", path, len(diags)), 1)
+}
+
+func handleConfMigrate(c *cli.Context) error {
+	configBytes, err := os.ReadFile(res.AppConfigPath)
+	if err != nil {
+		return err
+	}
+	migrated, from, err := model.MigrateConfig(configBytes)
+	if err != nil {
+		return err
+	}
+	if from >= res.ConfVersion {
+		log.Info("[CONFIG] already v%d, nothing to migrate", from)
+		return nil
+	}
+
+	// Only show the changes of content, not the indent or the order of keys
+	old, err := model.NormalizeConfig(configBytes)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("v%d -> v%d\n", from, res.ConfVersion)
+	fmt.Print(lineDiff(string(old), string(migrated)))
+
+	if !c.Bool("write") {
+		log.Info("[CONFIG] dry run, use `--write` to apply")
+		return nil
+	}
+	err = model.WriteMigratedConfig(res.AppConfigPath, configBytes, migrated)
+	if err != nil {
+		return err
+	}
+	log.Suc("[CONFIG] migrated, old config is backed up to %s.bak", res.AppConfigPath)
+	return nil
+}
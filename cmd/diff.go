@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"strings"
+)
+
+// lineDiff returns the line diff from a to b,
+// lines are prefixed by "- ", "+ " or "  ".
+func lineDiff(a, b string) string {
+	as := splitLines(a)
+	bs := splitLines(b)
+
+	// lcs[i][j] is the length of the LCS of as[i:] and bs[j:]
+	lcs := make([][]int, len(as)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(bs)+1)
+	}
+	for i := len(as) - 1; i >= 0; i-- {
+		for j := len(bs) - 1; j >= 0; j-- {
+			if as[i] == bs[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var sb strings.Builder
+	i, j := 0, 0
+	for i < len(as) || j < len(bs) {
+		switch {
+		case i < len(as) && j < len(bs) && as[i] == bs[j]:
+			sb.WriteString("  " + as[i] + "\n")
+			i++
+			j++
+		// Removed lines go before the added ones
+		case i < len(as) && (j == len(bs) || lcs[i+1][j] >= lcs[i][j+1]):
+			sb.WriteString("- " + as[i] + "\n")
+			i++
+		default:
+			sb.WriteString("+ " + bs[j] + "\n")
+			j++
+		}
+	}
+	return sb.String()
+}
+
+func splitLines(s string) []string {
+	s = strings.TrimRight(s, "\n")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
@@ -0,0 +1,22 @@
+package cmd
+
+import "testing"
+
+func TestLineDiff(t *testing.T) {
+	cases := []struct {
+		a, b   string
+		expect string
+	}{
+		{"a\nb\n", "a\nb\n", "  a\n  b\n"},
+		{"a\nc\n", "a\nb\nc\n", "  a\n+ b\n  c\n"},
+		{"a\nb\nc", "a\nc", "  a\n- b\n  c\n"},
+		{"a\nb", "a\nc", "  a\n- b\n+ c\n"},
+		{"", "a", "+ a\n"},
+		{"a\n", "", "- a\n"},
+	}
+	for _, c := range cases {
+		if got := lineDiff(c.a, c.b); got != c.expect {
+			t.Errorf("%q -> %q: expect %q, got %q", c.a, c.b, c.expect, got)
+		}
+	}
+}
@@ -1,6 +1,10 @@
 package cmd
 
 import (
+	"os"
+	"os/signal"
+	"syscall"
+
 	"github.com/lollipopkit/server_box_monitor/model"
 	"github.com/lollipopkit/server_box_monitor/runner"
 	"github.com/urfave/cli/v2"
@@ -32,16 +36,29 @@ func init() {
 				Usage:   "TLS key file path",
 				EnvVars: []string{"SBM_TLS_KEY"},
 			},
+			&cli.StringFlag{
+				Name:    "host-root",
+				Usage:   "Where / of the host is mounted, eg: /host in a container run with -v /:/host:ro,rslave",
+				Value:   "/",
+				EnvVars: []string{"SBM_HOST_ROOT"},
+			},
+			&cli.StringFlag{
+				Name:    "client-ca",
+				Usage:   "CA file to verify client certificates (mTLS), needs crt and key",
+				EnvVars: []string{"SBM_TLS_CLIENT_CA"},
+			},
 		},
 	})
 }
 
 func handleServe(ctx *cli.Context) error {
 	webConfig := &model.WebConfig{
-		Addr: ctx.String("addr"),
-		Cert: ctx.String("crt"),
-		Key:  ctx.String("key"),
+		Addr:     ctx.String("addr"),
+		Cert:     ctx.String("crt"),
+		Key:      ctx.String("key"),
+		ClientCA: ctx.String("client-ca"),
 	}
-	runner.Start(webConfig)
-	return nil
+	sigCtx, stop := signal.NotifyContext(ctx.Context, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return runner.Start(sigCtx, webConfig, model.NewHostFS(ctx.String("host-root")))
 }
@@ -6,6 +6,7 @@ require (
 	github.com/labstack/echo/v4 v4.11.1
 	github.com/lollipopkit/gommon v0.0.0-20231106103911-6f064c330015
 	github.com/urfave/cli/v2 v2.25.7
+	golang.org/x/crypto v0.11.0
 )
 
 require (
@@ -18,7 +19,6 @@ require (
 	github.com/valyala/bytebufferpool v1.0.0 // indirect
 	github.com/valyala/fasttemplate v1.2.2 // indirect
 	github.com/xrash/smetrics v0.0.0-20201216005158-039620a65673 // indirect
-	golang.org/x/crypto v0.11.0 // indirect
 	golang.org/x/net v0.12.0 // indirect
 	golang.org/x/sys v0.10.0 // indirect
 	golang.org/x/text v0.11.0 // indirect
@@ -0,0 +1,63 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrUnknownAggregate = errors.New("unknown aggregate function")
+	ErrInvalidWindow    = errors.New("invalid window")
+)
+
+type AggregateFunc string
+
+const (
+	AggregateNone       AggregateFunc = ""
+	AggregateAvg        AggregateFunc = "avg"
+	AggregateMin        AggregateFunc = "min"
+	AggregateMax        AggregateFunc = "max"
+	AggregatePercentile AggregateFunc = "p"
+)
+
+// Aggregate is the function applied to the values in a window
+// before comparing, eg: "avg5m" "max(1m)" "p95(10m)".
+type Aggregate struct {
+	Func AggregateFunc
+	// 0 ~ 100, only for AggregatePercentile
+	Percentile float64
+	Window     time.Duration
+}
+
+// Apply returns the aggregated value of samples.
+func (a Aggregate) Apply(samples Samples) (float64, error) {
+	if len(samples) == 0 {
+		return 0, ErrNotReady
+	}
+	switch a.Func {
+	case AggregateAvg:
+		return samples.Avg(), nil
+	case AggregateMin:
+		return samples.Min(), nil
+	case AggregateMax:
+		return samples.Max(), nil
+	case AggregatePercentile:
+		return samples.Percentile(a.Percentile), nil
+	}
+	return 0, fmt.Errorf("%w: %s", ErrUnknownAggregate, a.Func)
+}
+
+// String returns a in the form which ParseToThreshold accepts.
+// eg: "avg(5m0s)" "p95(10m0s)"
+func (a Aggregate) String() string {
+	if a.Func == AggregateNone {
+		return ""
+	}
+	name := string(a.Func)
+	if a.Func == AggregatePercentile {
+		name += strconv.FormatFloat(a.Percentile, 'f', -1, 64)
+	}
+	return fmt.Sprintf("%s(%s)", name, a.Window)
+}
@@ -0,0 +1,175 @@
+package model
+
+import (
+	"sync"
+	"time"
+
+	"github.com/lollipopkit/server_box_monitor/res"
+)
+
+type AlertState uint8
+
+const (
+	// Threshold is not reached
+	AlertStateInactive AlertState = iota
+	// Threshold is reached, but not for Rule.For yet
+	AlertStatePending
+	// Threshold has been reached for Rule.For
+	AlertStateFiring
+	// Threshold is not reached any more after firing,
+	// it's only returned once by AlertTracker.Update
+	AlertStateResolved
+)
+
+func (s AlertState) String() string {
+	switch s {
+	case AlertStatePending:
+		return "pending"
+	case AlertStateFiring:
+		return "firing"
+	case AlertStateResolved:
+		return "resolved"
+	}
+	return "inactive"
+}
+
+// Alert is the state of one rule.
+type Alert struct {
+	State AlertState
+	// When the threshold was reached first in a row
+	ActiveAt time.Time
+	// When the alert turned into firing
+	FiredAt time.Time
+	// When the alert turned into resolved
+	ResolvedAt time.Time
+	// Checks failed in a row, see AlertTracker.Fail
+	Failures int
+}
+
+// Duration returns how long the alert has been firing.
+func (a *Alert) Duration() time.Duration {
+	if a.FiredAt.IsZero() || a.ResolvedAt.IsZero() {
+		return 0
+	}
+	return a.ResolvedAt.Sub(a.FiredAt)
+}
+
+// AlertTracker tracks the state of rules between ticks,
+// the same way as the alerting rules of Prometheus:
+//
+//	inactive -> pending -> firing -> resolved -> inactive
+//
+// Any tick which doesn't reach the threshold resets the rule to inactive.
+// A firing rule only turns into resolved once its clear condition holds.
+type AlertTracker struct {
+	// Rule.Id() -> Alert
+	alerts map[string]*Alert
+	lock   sync.Mutex
+}
+
+func NewAlertTracker() *AlertTracker {
+	return &AlertTracker{alerts: map[string]*Alert{}}
+}
+
+// Update records the result of rule at now,
+// and returns the state of rule after it.
+// reached is from Rule.ShouldNotify, cleared is from Rule.ShouldClear.
+func (t *AlertTracker) Update(rule *Rule, reached, cleared bool, now time.Time) (Alert, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	id := rule.Id()
+	alert, ok := t.alerts[id]
+	if ok {
+		alert.Failures = 0
+	}
+	if ok && alert.State == AlertStateFiring {
+		// Keep firing between the threshold and the clear threshold
+		if reached || !cleared {
+			return *alert, nil
+		}
+		delete(t.alerts, id)
+		alert.State = AlertStateResolved
+		alert.ResolvedAt = now
+		return *alert, nil
+	}
+	if !reached {
+		delete(t.alerts, id)
+		return Alert{State: AlertStateInactive}, nil
+	}
+	forDuration, err := rule.GetFor()
+	if err != nil {
+		return Alert{}, err
+	}
+	if !ok {
+		alert = &Alert{State: AlertStatePending, ActiveAt: now}
+		t.alerts[id] = alert
+	}
+	if alert.State == AlertStatePending && now.Sub(alert.ActiveAt) >= forDuration {
+		alert.State = AlertStateFiring
+		alert.FiredAt = now
+	}
+	return *alert, nil
+}
+
+// Fail records that rule can't be checked at now, and returns the state of rule after it.
+// The state is kept for res.RuleStaleChecks failures in a row,
+// then a firing alert turns into resolved, and a pending one into inactive.
+func (t *AlertTracker) Fail(rule *Rule, now time.Time) Alert {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	id := rule.Id()
+	alert, ok := t.alerts[id]
+	if !ok {
+		return Alert{State: AlertStateInactive}
+	}
+	alert.Failures++
+	if alert.Failures < res.RuleStaleChecks {
+		return *alert
+	}
+	delete(t.alerts, id)
+	if alert.State != AlertStateFiring {
+		return Alert{State: AlertStateInactive}
+	}
+	alert.State = AlertStateResolved
+	alert.ResolvedAt = now
+	return *alert
+}
+
+// Get returns a copy of the state of rule.
+func (t *AlertTracker) Get(rule *Rule) Alert {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if alert, ok := t.alerts[rule.Id()]; ok {
+		return *alert
+	}
+	return Alert{State: AlertStateInactive}
+}
+
+// Active returns copies of the pending and firing alerts by Rule.Id().
+func (t *AlertTracker) Active() map[string]Alert {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	alerts := make(map[string]Alert, len(t.alerts))
+	for id, alert := range t.alerts {
+		alerts[id] = *alert
+	}
+	return alerts
+}
+
+// Retain drops the states of rules which are not in rules,
+// eg: after reloading the config.
+func (t *AlertTracker) Retain(rules []Rule) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	ids := make(map[string]bool, len(rules))
+	for i := range rules {
+		ids[rules[i].Id()] = true
+	}
+	for id := range t.alerts {
+		if !ids[id] {
+			delete(t.alerts, id)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package model_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lollipopkit/server_box_monitor/model"
+	"github.com/lollipopkit/server_box_monitor/res"
+)
+
+func TestAlertTrackerFor(t *testing.T) {
+	tracker := model.NewAlertTracker()
+	rule := &model.Rule{MonitorType: model.MonitorTypeCPU, Threshold: ">=77%", Matcher: "cpu", For: "2m"}
+	start := time.Unix(0, 0)
+
+	steps := []struct {
+		after   time.Duration
+		reached bool
+		state   model.AlertState
+	}{
+		{0, true, model.AlertStatePending},
+		{time.Minute, true, model.AlertStatePending},
+		// One tick below the threshold resets it
+		{time.Minute + 7*time.Second, false, model.AlertStateInactive},
+		{2 * time.Minute, true, model.AlertStatePending},
+		{4 * time.Minute, true, model.AlertStateFiring},
+		{5 * time.Minute, true, model.AlertStateFiring},
+		{6 * time.Minute, false, model.AlertStateResolved},
+		{7 * time.Minute, false, model.AlertStateInactive},
+	}
+	for i, step := range steps {
+		alert, err := tracker.Update(rule, step.reached, !step.reached, start.Add(step.after))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if alert.State != step.state {
+			t.Errorf("step %d: expect %s, got %s", i, step.state, alert.State)
+		}
+	}
+}
+
+func TestAlertTrackerResolved(t *testing.T) {
+	tracker := model.NewAlertTracker()
+	rule := &model.Rule{MonitorType: model.MonitorTypeCPU, Threshold: ">=77%", Matcher: "cpu"}
+	start := time.Unix(0, 0)
+	tracker.Update(rule, true, false, start)
+	tracker.Update(rule, true, false, start.Add(time.Minute))
+	alert, err := tracker.Update(rule, false, true, start.Add(3*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alert.State != model.AlertStateResolved || alert.Duration() != 3*time.Minute {
+		t.Errorf("expect resolved after 3m, got %s after %s", alert.State, alert.Duration())
+	}
+	// Resolved is only reported once
+	if state := tracker.Get(rule).State; state != model.AlertStateInactive {
+		t.Errorf("expect inactive, got %s", state)
+	}
+}
+
+func TestAlertTrackerWithoutFor(t *testing.T) {
+	tracker := model.NewAlertTracker()
+	rule := &model.Rule{MonitorType: model.MonitorTypeCPU, Threshold: ">=77%", Matcher: "cpu"}
+	alert, err := tracker.Update(rule, true, false, time.Now())
+	if err != nil || alert.State != model.AlertStateFiring {
+		t.Fatalf("expect firing at the first tick, got %s %v", alert.State, err)
+	}
+
+	tracker.Retain(nil)
+	if state := tracker.Get(rule).State; state != model.AlertStateInactive {
+		t.Errorf("expect inactive after retain, got %s", state)
+	}
+}
+
+func TestAlertTrackerHysteresis(t *testing.T) {
+	tracker := model.NewAlertTracker()
+	rule := &model.Rule{MonitorType: model.MonitorTypeCPU, Threshold: ">=80%", Matcher: "cpu", Clear: "<70%"}
+	start := time.Unix(0, 0)
+	steps := []struct {
+		reached, cleared bool
+		state            model.AlertState
+	}{
+		// 75%
+		{false, false, model.AlertStateInactive},
+		// 85%
+		{true, false, model.AlertStateFiring},
+		// 75%, hovering between the thresholds
+		{false, false, model.AlertStateFiring},
+		// 82%
+		{true, false, model.AlertStateFiring},
+		// 65%
+		{false, true, model.AlertStateResolved},
+	}
+	for i, step := range steps {
+		alert, err := tracker.Update(rule, step.reached, step.cleared, start.Add(time.Duration(i)*time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if alert.State != step.state {
+			t.Errorf("step %d: expect %s, got %s", i, step.state, alert.State)
+		}
+	}
+}
+
+func TestAlertTrackerFail(t *testing.T) {
+	tracker := model.NewAlertTracker()
+	rule := &model.Rule{MonitorType: model.MonitorTypeDisk, Threshold: ">=90%", Matcher: "/mnt"}
+	start := time.Unix(0, 0)
+	tracker.Update(rule, true, false, start)
+	// A successful check resets the failures
+	tracker.Fail(rule, start)
+	tracker.Update(rule, true, false, start)
+	for i := 1; i < res.RuleStaleChecks; i++ {
+		if alert := tracker.Fail(rule, start.Add(time.Duration(i)*time.Minute)); alert.State != model.AlertStateFiring || alert.Failures != i {
+			t.Fatalf("failure %d: expect firing, got %s after %d failures", i, alert.State, alert.Failures)
+		}
+	}
+	alert := tracker.Fail(rule, start.Add(time.Hour))
+	if alert.State != model.AlertStateResolved || alert.Duration() != time.Hour {
+		t.Errorf("expect resolved after 1h, got %s after %s", alert.State, alert.Duration())
+	}
+	if state := tracker.Get(rule).State; state != model.AlertStateInactive {
+		t.Errorf("expect inactive, got %s", state)
+	}
+	// Nothing to expire
+	if alert := tracker.Fail(rule, start.Add(time.Hour)); alert.State != model.AlertStateInactive {
+		t.Errorf("expect inactive, got %s", alert.State)
+	}
+}
@@ -0,0 +1,142 @@
+package model
+
+import (
+	"crypto/subtle"
+	"fmt"
+
+	"github.com/lollipopkit/server_box_monitor/res"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type AuthScope string
+
+const (
+	// Reading status, history, rules, etc.
+	AuthScopeRead AuthScope = "read"
+	// Changing the config, it covers AuthScopeRead
+	AuthScopeAdmin AuthScope = "admin"
+)
+
+// Compared with when the user is unknown, in bcrypt.DefaultCost
+var dummyHash = []byte("$2a$10$F28qNqvKi.3v3d9vJbFpzOORWPrNCnGUK09UlnLx6gGA50GhNCYx6")
+
+// Allows reports whether s covers scope.
+func (s AuthScope) Allows(scope AuthScope) bool {
+	return s == scope || s == AuthScopeAdmin
+}
+
+// AuthConfig is about who can access the HTTP API.
+// Everyone can read if neither Tokens nor Users is set,
+// but nobody is admin then.
+type AuthConfig struct {
+	// Sent as `Authorization: Bearer <token>`
+	Tokens []AuthToken `json:"tokens,omitempty"`
+	// Sent as HTTP basic auth
+	Users []AuthUser `json:"users,omitempty"`
+	// Origins allowed by CORS when auth is enabled,
+	// such as "https://example.com". Empty means same origin only.
+	Origins []string `json:"origins,omitempty"`
+}
+
+type AuthToken struct {
+	Token string `json:"token"`
+	// Default is AuthScopeRead
+	Scope AuthScope `json:"scope,omitempty"`
+}
+
+type AuthUser struct {
+	Name string `json:"name"`
+	// Bcrypt hash of the password,
+	// eg: the output of `htpasswd -nbB name password` after the colon
+	Hash string `json:"hash"`
+	// Default is AuthScopeRead
+	Scope AuthScope `json:"scope,omitempty"`
+}
+
+func (a *AuthConfig) Enabled() bool {
+	return a != nil && (len(a.Tokens) > 0 || len(a.Users) > 0)
+}
+
+// ByToken returns the scope of token, ok is false if it's unknown.
+func (a *AuthConfig) ByToken(token string) (scope AuthScope, ok bool) {
+	if a == nil || token == "" {
+		return "", false
+	}
+	for _, t := range a.Tokens {
+		// Checks all tokens in constant time
+		if subtle.ConstantTimeCompare([]byte(t.Token), []byte(token)) == 1 && !ok {
+			scope, ok = getScope(t.Scope), true
+		}
+	}
+	return scope, ok
+}
+
+// ByUser returns the scope of user name, ok is false if
+// the user is unknown or the password is wrong.
+func (a *AuthConfig) ByUser(name, password string) (scope AuthScope, ok bool) {
+	if a == nil {
+		return "", false
+	}
+	var user *AuthUser
+	for i := range a.Users {
+		if a.Users[i].Name == name {
+			user = &a.Users[i]
+			break
+		}
+	}
+	// Unknown users take as long as wrong passwords,
+	// or they can be told apart by the time of responses.
+	if user == nil {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return "", false
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.Hash), []byte(password)) != nil {
+		return "", false
+	}
+	return getScope(user.Scope), true
+}
+
+func getScope(scope AuthScope) AuthScope {
+	if scope == "" {
+		return AuthScopeRead
+	}
+	return scope
+}
+
+func diagnoseScope(path string, scope AuthScope) []*ConfigError {
+	switch scope {
+	case "", AuthScopeRead, AuthScopeAdmin:
+		return nil
+	}
+	return []*ConfigError{newConfigError(path, "unknown scope: %s", scope)}
+}
+
+func (a *AuthConfig) diagnose(path string) []*ConfigError {
+	diags := []*ConfigError{}
+	tokens := map[string]bool{}
+	for i, t := range a.Tokens {
+		tokenPath := fmt.Sprintf("%s.tokens[%d]", path, i)
+		if len(t.Token) < res.MinAuthTokenLen {
+			diags = append(diags, newConfigError(tokenPath+".token", "token should have at least %d characters", res.MinAuthTokenLen))
+		} else if tokens[t.Token] {
+			diags = append(diags, newConfigError(tokenPath+".token", "duplicate token"))
+		}
+		tokens[t.Token] = true
+		diags = append(diags, diagnoseScope(tokenPath+".scope", t.Scope)...)
+	}
+	names := map[string]bool{}
+	for i, u := range a.Users {
+		userPath := fmt.Sprintf("%s.users[%d]", path, i)
+		if u.Name == "" {
+			diags = append(diags, newConfigError(userPath+".name", "name is empty"))
+		} else if names[u.Name] {
+			diags = append(diags, newConfigError(userPath+".name", "duplicate name: %s", u.Name))
+		}
+		names[u.Name] = true
+		if _, err := bcrypt.Cost([]byte(u.Hash)); err != nil {
+			diags = append(diags, newConfigError(userPath+".hash", "invalid bcrypt hash: %v", err))
+		}
+		diags = append(diags, diagnoseScope(userPath+".scope", u.Scope)...)
+	}
+	return diags
+}
@@ -0,0 +1,57 @@
+package model_test
+
+import (
+	"testing"
+
+	"github.com/lollipopkit/server_box_monitor/model"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestAuthConfig(t *testing.T) {
+	var disabled *model.AuthConfig
+	if disabled.Enabled() {
+		t.Error("nil auth should not be enabled")
+	}
+	if _, ok := disabled.ByToken("0123456789abcdef"); ok {
+		t.Error("nil auth should not accept tokens")
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("pass"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := &model.AuthConfig{
+		Tokens: []model.AuthToken{
+			{Token: "0123456789abcdef"},
+			{Token: "fedcba9876543210", Scope: model.AuthScopeAdmin},
+		},
+		Users: []model.AuthUser{{Name: "admin", Hash: string(hash), Scope: model.AuthScopeAdmin}},
+	}
+	if !auth.Enabled() {
+		t.Error("auth should be enabled")
+	}
+	if scope, ok := auth.ByToken("0123456789abcdef"); !ok || scope != model.AuthScopeRead {
+		t.Errorf("expect read, got %q %v", scope, ok)
+	}
+	if scope, ok := auth.ByToken("fedcba9876543210"); !ok || scope != model.AuthScopeAdmin {
+		t.Errorf("expect admin, got %q %v", scope, ok)
+	}
+	for _, token := range []string{"", "0123456789abcde", "0123456789abcdefg"} {
+		if _, ok := auth.ByToken(token); ok {
+			t.Errorf("%q should not be accepted", token)
+		}
+	}
+	if scope, ok := auth.ByUser("admin", "pass"); !ok || scope != model.AuthScopeAdmin {
+		t.Errorf("expect admin, got %q %v", scope, ok)
+	}
+	if _, ok := auth.ByUser("admin", "wrong"); ok {
+		t.Error("wrong password should not be accepted")
+	}
+	if _, ok := auth.ByUser("root", "pass"); ok {
+		t.Error("unknown user should not be accepted")
+	}
+
+	if !model.AuthScopeAdmin.Allows(model.AuthScopeRead) || model.AuthScopeRead.Allows(model.AuthScopeAdmin) {
+		t.Error("admin should cover read, but not the other way")
+	}
+}
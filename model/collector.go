@@ -0,0 +1,126 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Collector reads one part of the server status and
+// answers rules of the monitor types it provides.
+//
+// New collectors should be registered in `init()` by RegisterCollector.
+type Collector interface {
+	// eg: "cpu"
+	Name() string
+	// Monitor types which rules can use, eg: [MonitorTypeCPU]
+	Types() []MonitorType
+	// Threshold types which rules of typ can use,
+	// eg: [ThresholdTypePercent] for MonitorTypeCPU
+	ThresholdTypes(typ MonitorType) []ThresholdType
+	// Matchers returns the matchers of all values in s,
+	// they are recorded in History every tick.
+	// eg: ["cpu", "cpu0", "cpu1"]
+	Matchers(s *ServerStatus) []string
+	// Collect reads the host under fs and writes its part into s.
+	Collect(fs *HostFS, s *ServerStatus) error
+	// Value returns the value in s which matcher points to,
+	// in the unit of tt.
+	// It returns ErrNotReady if s has not enough samples yet.
+	Value(s *ServerStatus, typ MonitorType, matcher string, tt ThresholdType) (*Value, error)
+}
+
+// MatcherChecker is implemented by collectors which can tell
+// whether a matcher is valid without the status, it's used by Validate.
+type MatcherChecker interface {
+	// CheckMatcher returns an error wrapping ErrUnknownMatcher if matcher is invalid.
+	CheckMatcher(typ MonitorType, matcher string) error
+}
+
+var (
+	ErrUnknownMatcher = errors.New("unknown matcher")
+)
+
+var (
+	collectors = []Collector{}
+)
+
+// checkMatcherIn checks matcher is one of allowed.
+func checkMatcherIn(matcher string, allowed ...string) error {
+	for _, a := range allowed {
+		if matcher == a {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %q, use %s", ErrUnknownMatcher, matcher, strings.Join(allowed, " / "))
+}
+
+// checkMatcherNotEmpty is for matchers which depend on the host, such as disks.
+func checkMatcherNotEmpty(matcher string) error {
+	if matcher == "" {
+		return fmt.Errorf("%w: matcher is empty", ErrUnknownMatcher)
+	}
+	return nil
+}
+
+// RegisterCollector adds c to the collectors which run every tick.
+// It panics if another collector already provides one of c's types.
+func RegisterCollector(c Collector) {
+	for _, typ := range c.Types() {
+		if exist := CollectorOf(typ); exist != nil {
+			panic(fmt.Sprintf("monitor type %s is provided by both %s and %s", typ, exist.Name(), c.Name()))
+		}
+	}
+	collectors = append(collectors, c)
+}
+
+// CollectorOf returns the collector which provides typ, or nil.
+func CollectorOf(typ MonitorType) Collector {
+	for _, c := range collectors {
+		for _, t := range c.Types() {
+			if t == typ {
+				return c
+			}
+		}
+	}
+	return nil
+}
+
+func Collectors() []Collector {
+	return collectors
+}
+
+// Value is one number read from the status.
+type Value struct {
+	// Used as the key of PushPair
+	// eg: "cpu0" "Mem free" "/dev/sda1"
+	Key string
+	ThresholdType
+	Value float64
+}
+
+func (v *Value) String() string {
+	// Changes can be negative
+	if v.Value < 0 {
+		return "-" + (&Value{ThresholdType: v.ThresholdType, Value: -v.Value}).String()
+	}
+	switch v.ThresholdType {
+	case ThresholdTypePercent:
+		return fmt.Sprintf("%.2f%%", v.Value)
+	case ThresholdTypeSize:
+		return Size(v.Value).String()
+	case ThresholdTypeSpeed:
+		return Size(v.Value).String() + "/s"
+	case ThresholdTypeTemperature:
+		return fmt.Sprintf("%.2f°C", v.Value)
+	}
+	return fmt.Sprintf("%.2f", v.Value)
+}
+
+func (v *Value) PushPair() *PushPair {
+	return NewPushPair(v.Key, v.String())
+}
+
+func errInvalidThresholdType(name string, tt ThresholdType) error {
+	return errors.Join(ErrInvalidRule, fmt.Errorf("invalid threshold type for %s: %s", name, tt.Name()))
+}
@@ -0,0 +1,88 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	RegisterCollector(cpuCollector{})
+}
+
+type cpuCollector struct{}
+
+func (cpuCollector) Name() string {
+	return "cpu"
+}
+
+func (cpuCollector) Types() []MonitorType {
+	return []MonitorType{MonitorTypeCPU}
+}
+
+func (cpuCollector) ThresholdTypes(MonitorType) []ThresholdType {
+	return []ThresholdType{ThresholdTypePercent}
+}
+
+func (cpuCollector) Matchers(s *ServerStatus) []string {
+	matchers := make([]string, 0, len(s.CPU))
+	for i := range s.CPU {
+		if i == 0 {
+			matchers = append(matchers, "cpu")
+		} else {
+			matchers = append(matchers, fmt.Sprintf("cpu%d", i-1))
+		}
+	}
+	return matchers
+}
+
+func (cpuCollector) CheckMatcher(_ MonitorType, matcher string) error {
+	if matcher == "" || matcher == "cpu" {
+		return nil
+	}
+	if _, err := strconv.ParseUint(strings.TrimPrefix(matcher, "cpu"), 10, 64); err != nil || !strings.HasPrefix(matcher, "cpu") {
+		return fmt.Errorf("%w: %q, use cpu / cpuN", ErrUnknownMatcher, matcher)
+	}
+	return nil
+}
+
+func (cpuCollector) Collect(fs *HostFS, s *ServerStatus) error {
+	return fs.ReadCPUStatus(s)
+}
+
+func (cpuCollector) Value(s *ServerStatus, _ MonitorType, matcher string, tt ThresholdType) (*Value, error) {
+	if len(s.CPU) == 0 {
+		return nil, ErrNotReady
+	}
+	// 默认获取所有cpu
+	// cpu -> idx = 0 （默认）
+	// cpu0 -> idx = 1
+	// idx = CPU序号 + 1
+	var idx int64 = 0
+	if matcher != "" && matcher != "cpu" {
+		idx_, err := strconv.ParseUint(strings.Replace(matcher, "cpu", "", 1), 10, 64)
+		if err != nil {
+			return nil, errors.Join(ErrInvalidRule, err)
+		}
+		idx = int64(idx_ + 1)
+	}
+
+	if idx < 0 || int(idx) >= len(s.CPU) {
+		return nil, errors.Join(ErrInvalidRule, fmt.Errorf("cpu index out of range: %d", idx))
+	}
+	switch tt {
+	case ThresholdTypePercent:
+		percent, err := s.CPU[idx].UsedPercent()
+		if err != nil {
+			return nil, err
+		}
+		key := "cpu"
+		if idx > 0 {
+			key = fmt.Sprintf("cpu%d", idx-1)
+		}
+		return &Value{Key: key, ThresholdType: tt, Value: percent}, nil
+	default:
+		return nil, errInvalidThresholdType("cpu", tt)
+	}
+}
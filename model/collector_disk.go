@@ -0,0 +1,69 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+)
+
+func init() {
+	RegisterCollector(diskCollector{})
+}
+
+type diskCollector struct{}
+
+func (diskCollector) Name() string {
+	return "disk"
+}
+
+func (diskCollector) Types() []MonitorType {
+	return []MonitorType{MonitorTypeDisk}
+}
+
+func (diskCollector) ThresholdTypes(MonitorType) []ThresholdType {
+	return []ThresholdType{ThresholdTypeSize, ThresholdTypePercent}
+}
+
+func (diskCollector) Matchers(s *ServerStatus) []string {
+	matchers := make([]string, 0, len(s.Disk))
+	for _, d := range s.Disk {
+		matchers = append(matchers, d.MountPath)
+	}
+	return matchers
+}
+
+func (diskCollector) CheckMatcher(_ MonitorType, matcher string) error {
+	return checkMatcherNotEmpty(matcher)
+}
+
+func (diskCollector) Collect(fs *HostFS, s *ServerStatus) error {
+	return fs.ReadDiskStatus(s)
+}
+
+func (diskCollector) Value(s *ServerStatus, _ MonitorType, matcher string, tt ThresholdType) (*Value, error) {
+	disk, err := findDisk(s, matcher)
+	if err != nil {
+		return nil, err
+	}
+
+	switch tt {
+	case ThresholdTypeSize:
+		return &Value{Key: matcher, ThresholdType: tt, Value: float64(disk.Used)}, nil
+	case ThresholdTypePercent:
+		return &Value{Key: matcher, ThresholdType: tt, Value: disk.UsedPercent}, nil
+	default:
+		return nil, errInvalidThresholdType("disk", tt)
+	}
+}
+
+// findDisk returns the disk whose mount path or filesystem is matcher.
+func findDisk(s *ServerStatus, matcher string) (*diskStatus, error) {
+	if len(s.Disk) == 0 {
+		return nil, ErrNotReady
+	}
+	for i := range s.Disk {
+		if s.Disk[i].MountPath == matcher || s.Disk[i].Filesystem == matcher {
+			return &s.Disk[i], nil
+		}
+	}
+	return nil, errors.Join(ErrInvalidRule, fmt.Errorf("disk not found: %s", matcher))
+}
@@ -0,0 +1,121 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+)
+
+func init() {
+	RegisterCollector(memCollector{})
+	RegisterCollector(swapCollector{})
+}
+
+type memCollector struct{}
+
+func (memCollector) Name() string {
+	return "mem"
+}
+
+func (memCollector) Types() []MonitorType {
+	return []MonitorType{MonitorTypeMemory}
+}
+
+func (memCollector) ThresholdTypes(MonitorType) []ThresholdType {
+	return []ThresholdType{ThresholdTypeSize, ThresholdTypePercent}
+}
+
+func (memCollector) Matchers(*ServerStatus) []string {
+	return []string{"avail", "free", "used"}
+}
+
+func (memCollector) CheckMatcher(_ MonitorType, matcher string) error {
+	return checkMatcherIn(matcher, "avail", "free", "used")
+}
+
+func (memCollector) Collect(fs *HostFS, s *ServerStatus) error {
+	meminfo, err := fs.ReadMeminfo()
+	if err != nil {
+		return err
+	}
+	return s.ParseMemStatus(meminfo)
+}
+
+func (memCollector) Value(s *ServerStatus, _ MonitorType, matcher string, tt ThresholdType) (*Value, error) {
+	if s.Mem == nil {
+		return nil, ErrNotReady
+	}
+	var size Size
+	switch matcher {
+	case "avail":
+		size = s.Mem.Avail
+	case "free":
+		size = s.Mem.Free
+	case "used":
+		size = s.Mem.Used
+	default:
+		return nil, errors.Join(ErrInvalidRule, fmt.Errorf("invalid matcher: %s", matcher))
+	}
+	return sizeOrPercentValue("Mem "+matcher, size, s.Mem.Total, tt)
+}
+
+type swapCollector struct{}
+
+func (swapCollector) Name() string {
+	return "swap"
+}
+
+func (swapCollector) Types() []MonitorType {
+	return []MonitorType{MonitorTypeSwap}
+}
+
+func (swapCollector) ThresholdTypes(MonitorType) []ThresholdType {
+	return []ThresholdType{ThresholdTypeSize, ThresholdTypePercent}
+}
+
+func (swapCollector) Matchers(*ServerStatus) []string {
+	return []string{"used", "free"}
+}
+
+func (swapCollector) CheckMatcher(_ MonitorType, matcher string) error {
+	return checkMatcherIn(matcher, "used", "free")
+}
+
+func (swapCollector) Collect(fs *HostFS, s *ServerStatus) error {
+	meminfo, err := fs.ReadMeminfo()
+	if err != nil {
+		return err
+	}
+	return s.ParseSwapStatus(meminfo)
+}
+
+func (swapCollector) Value(s *ServerStatus, _ MonitorType, matcher string, tt ThresholdType) (*Value, error) {
+	if s.Swap == nil {
+		return nil, ErrNotReady
+	}
+	var size Size
+	switch matcher {
+	case "used":
+		size = s.Swap.Used
+	case "free":
+		size = s.Swap.Free
+	default:
+		return nil, errors.Join(ErrInvalidRule, fmt.Errorf("invalid matcher: %s", matcher))
+	}
+	return sizeOrPercentValue("Swap "+matcher, size, s.Swap.Total, tt)
+}
+
+func sizeOrPercentValue(key string, size, total Size, tt ThresholdType) (*Value, error) {
+	switch tt {
+	case ThresholdTypeSize:
+		return &Value{Key: key, ThresholdType: tt, Value: float64(size)}, nil
+	case ThresholdTypePercent:
+		if total == 0 {
+			return nil, ErrNotReady
+		}
+		// 使用百分比来对比
+		percent := float64(size) / float64(total) * 100
+		return &Value{Key: key, ThresholdType: tt, Value: percent}, nil
+	default:
+		return nil, errInvalidThresholdType(key, tt)
+	}
+}
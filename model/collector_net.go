@@ -0,0 +1,108 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+func init() {
+	RegisterCollector(netCollector{})
+}
+
+type netCollector struct{}
+
+func (netCollector) Name() string {
+	return "net"
+}
+
+func (netCollector) Types() []MonitorType {
+	return []MonitorType{MonitorTypeNetwork}
+}
+
+func (netCollector) ThresholdTypes(MonitorType) []ThresholdType {
+	return []ThresholdType{ThresholdTypeSpeed, ThresholdTypeSize}
+}
+
+func (netCollector) Matchers(s *ServerStatus) []string {
+	matchers := make([]string, 0, len(s.Network)*3)
+	for _, n := range s.Network {
+		matchers = append(matchers, n.Interface, n.Interface+"-in", n.Interface+"-out")
+	}
+	return matchers
+}
+
+func (netCollector) CheckMatcher(_ MonitorType, matcher string) error {
+	return checkMatcherNotEmpty(matcher)
+}
+
+func (netCollector) Collect(fs *HostFS, s *ServerStatus) error {
+	return fs.ReadNetworkStatus(s)
+}
+
+func (netCollector) Value(s *ServerStatus, _ MonitorType, matcher string, tt ThresholdType) (*Value, error) {
+	if len(s.Network) == 0 {
+		return nil, ErrNotReady
+	}
+
+	net, in, out := findInterface(s, matcher)
+	if net == nil {
+		return nil, errors.Join(ErrInvalidRule, fmt.Errorf("network interface not found: %s", matcher))
+	}
+
+	switch tt {
+	case ThresholdTypeSpeed:
+		speed := Size(0)
+		if in {
+			s, err := net.ReceiveSpeed()
+			if err != nil {
+				return nil, err
+			}
+			speed += s
+		}
+		if out {
+			s, err := net.TransmitSpeed()
+			if err != nil {
+				return nil, err
+			}
+			speed += s
+		}
+		return &Value{Key: matcher, ThresholdType: tt, Value: float64(speed)}, nil
+	case ThresholdTypeSize:
+		size := Size(0)
+		if in {
+			size += net.Receive()
+		}
+		if out {
+			size += net.Transmit()
+		}
+		return &Value{Key: matcher, ThresholdType: tt, Value: float64(size)}, nil
+	default:
+		return nil, errInvalidThresholdType("network", tt)
+	}
+}
+
+// findInterface returns the interface of matcher and the directions to count.
+// matcher is "<iface>", "<iface>-in" or "<iface>-out",
+// the interface name is compared exactly, eg: "br-int" is an interface.
+func findInterface(s *ServerStatus, matcher string) (net *networkStatus, in, out bool) {
+	find := func(name string) *networkStatus {
+		for i := range s.Network {
+			if s.Network[i].Interface == name {
+				return &s.Network[i]
+			}
+		}
+		return nil
+	}
+	// 没有指定方向，则计算 出+入 流量
+	if net := find(matcher); net != nil {
+		return net, true, true
+	}
+	if name, found := strings.CutSuffix(matcher, "-in"); found {
+		return find(name), true, false
+	}
+	if name, found := strings.CutSuffix(matcher, "-out"); found {
+		return find(name), false, true
+	}
+	return nil, false, false
+}
@@ -0,0 +1,91 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+func init() {
+	RegisterCollector(tempCollector{})
+}
+
+type tempCollector struct{}
+
+func (tempCollector) Name() string {
+	return "temp"
+}
+
+func (tempCollector) Types() []MonitorType {
+	return []MonitorType{MonitorTypeTemperature}
+}
+
+func (tempCollector) ThresholdTypes(MonitorType) []ThresholdType {
+	return []ThresholdType{ThresholdTypeTemperature}
+}
+
+// Matchers are the zones, and the types which only one zone has.
+func (tempCollector) Matchers(s *ServerStatus) []string {
+	types := map[string]int{}
+	for _, t := range s.Temperature {
+		types[t.Name]++
+	}
+	matchers := make([]string, 0, len(s.Temperature)*2)
+	for _, t := range s.Temperature {
+		matchers = append(matchers, t.Zone)
+		if types[t.Name] == 1 {
+			matchers = append(matchers, t.Name)
+		}
+	}
+	return matchers
+}
+
+func (tempCollector) CheckMatcher(_ MonitorType, matcher string) error {
+	return checkMatcherNotEmpty(matcher)
+}
+
+func (tempCollector) Collect(fs *HostFS, s *ServerStatus) error {
+	return fs.ReadTemperatureStatus(s)
+}
+
+func (tempCollector) Value(s *ServerStatus, _ MonitorType, matcher string, tt ThresholdType) (*Value, error) {
+	if len(s.Temperature) == 0 {
+		return nil, ErrNotReady
+	}
+
+	temp, err := findTemperature(s, matcher)
+	if err != nil {
+		return nil, err
+	}
+
+	switch tt {
+	case ThresholdTypeTemperature:
+		return &Value{Key: matcher, ThresholdType: tt, Value: temp.Value}, nil
+	default:
+		return nil, errInvalidThresholdType("temperature", tt)
+	}
+}
+
+// findTemperature returns the zone whose name is matcher, eg: "thermal_zone0",
+// or the only zone whose type is matcher, eg: "x86_pkg_temp".
+func findTemperature(s *ServerStatus, matcher string) (*temperatureStatus, error) {
+	zones := []string{}
+	var found *temperatureStatus
+	for i := range s.Temperature {
+		t := &s.Temperature[i]
+		if t.Zone == matcher {
+			return t, nil
+		}
+		if t.Name == matcher {
+			found = t
+			zones = append(zones, t.Zone)
+		}
+	}
+	switch len(zones) {
+	case 0:
+		return nil, errors.Join(ErrInvalidRule, fmt.Errorf("temperature not found: %s", matcher))
+	case 1:
+		return found, nil
+	}
+	return nil, errors.Join(ErrInvalidRule, fmt.Errorf("%d zones are %s, use one of %s", len(zones), matcher, strings.Join(zones, " / ")))
+}
@@ -3,7 +3,10 @@ package model
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -15,9 +18,7 @@ import (
 )
 
 var (
-	Config        = new(AppConfig)
-	CheckInterval time.Duration
-	RateLimiter   *rate.RateLimiter[string]
+	ErrInvalidConfig = errors.New("invalid config")
 )
 
 type AppConfig struct {
@@ -30,100 +31,271 @@ type AppConfig struct {
 	Name     string `json:"name"`
 	Rules    []Rule `json:"rules"`
 	Pushes   []Push `json:"pushes"`
+	// Nil means the default retention
+	History *HistoryConfig `json:"history,omitempty"`
+	// Nil means everyone can read the HTTP API
+	Auth *AuthConfig `json:"auth,omitempty"`
 }
 
+// HistoryConfig is about the history on disk.
+type HistoryConfig struct {
+	// Not writing history to disk, it takes effect after restarting
+	Disabled bool `json:"disabled,omitempty"`
+	// How long raw values are kept, such as "24h"
+	Raw string `json:"raw,omitempty"`
+	// How long values rolled up per minute are kept, such as "720h"
+	Rollup string `json:"rollup,omitempty"`
+}
+
+// InitConfig writes the default config to res.AppConfigPath.
 func InitConfig() error {
+	data, err := EncodeAppConfig(DefaultAppConfig)
+	if err != nil {
+		log.Err("[CONFIG] marshal default app config failed: %v", err)
+		return err
+	}
+	err = os.WriteFile(res.AppConfigPath, data, newConfigFileMode)
+	if err != nil {
+		log.Err("[CONFIG] write default app config failed: %v", err)
+		return err
+	}
+	return nil
+}
+
+// EncodeAppConfig returns c in the format of the config file.
+func EncodeAppConfig(c *AppConfig) ([]byte, error) {
+	return encodeConfig(c)
+}
+
+func encodeConfig(v any) ([]byte, error) {
 	buf := new(bytes.Buffer)
 	enc := json.NewEncoder(buf)
 	enc.SetEscapeHTML(false)
 	enc.SetIndent("", "\t")
-	err := enc.Encode(DefaultAppConfig)
+	err := enc.Encode(v)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Configs have tokens and keys of pushes in them,
+// so new ones are only readable by the owner.
+const newConfigFileMode os.FileMode = 0600
+
+// ConfigFileMode returns the permission of path,
+// or newConfigFileMode if it doesn't exist.
+func ConfigFileMode(path string) os.FileMode {
+	info, err := os.Stat(path)
+	if err != nil {
+		return newConfigFileMode
+	}
+	return info.Mode().Perm()
+}
+
+// WriteAppConfig writes c to path atomically,
+// readers of path see either the old config or the new one.
+// The permission of path is kept.
+func WriteAppConfig(path string, c *AppConfig) error {
+	data, err := EncodeAppConfig(c)
 	if err != nil {
-		log.Err("[CONFIG] marshal default app config failed: %v", err)
 		return err
 	}
-	err = os.WriteFile(res.AppConfigPath, buf.Bytes(), 0644)
+	return WriteConfigFile(path, data, ConfigFileMode(path))
+}
+
+// WriteMigratedConfig backs up old to `path.bak` and writes migrated to path,
+// both in the permission of path.
+func WriteMigratedConfig(path string, old, migrated []byte) error {
+	mode := ConfigFileMode(path)
+	err := WriteConfigFile(path+".bak", old, mode)
 	if err != nil {
-		log.Err("[CONFIG] write default app config failed: %v", err)
 		return err
 	}
-	Config = DefaultAppConfig
-	return nil
+	return WriteConfigFile(path, migrated, mode)
+}
+
+// WriteConfigFile writes data to path atomically with the permission mode.
+func WriteConfigFile(path string, data []byte, mode os.FileMode) error {
+	// Rename only works in the same filesystem
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err == nil {
+		err = tmp.Chmod(mode)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Clone returns a deep copy of c.
+func (c *AppConfig) Clone() (*AppConfig, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	clone := new(AppConfig)
+	err = json.Unmarshal(data, clone)
+	if err != nil {
+		return nil, err
+	}
+	return clone, nil
 }
 
-func ReadAppConfig() error {
-	defer initInterval()
-	defer initRateLimiter()
+// ReadAppConfig reads res.AppConfigPath,
+// the default config will be written if it doesn't exist.
+// Old configs are migrated and written back, the origin is kept in `.bak`.
+func ReadAppConfig() (*AppConfig, error) {
 	if !sys.Exist(res.AppConfigPath) {
-		return InitConfig()
+		err := InitConfig()
+		if err != nil {
+			return nil, err
+		}
+		config := *DefaultAppConfig
+		return &config, nil
 	}
 
 	configBytes, err := os.ReadFile(res.AppConfigPath)
 	if err != nil {
 		log.Err("[CONFIG] read app config failed: %v", err)
-		return err
+		return nil, err
 	}
-	err = json.Unmarshal(configBytes, Config)
+	migrated, from, err := MigrateConfig(configBytes)
 	if err != nil {
-		log.Err("[CONFIG] unmarshal app config failed: %v", err)
-	} else if Config.Version < DefaultAppConfig.Version {
-		log.Warn("[CONFIG] app config version is too old, new config will be generated")
-		// Backup old config
-		err = os.WriteFile(res.AppConfigPath+".bak", configBytes, 0644)
-		if err != nil {
-			log.Err("[CONFIG] backup old config failed: %v", err)
-			return err
-		}
-		// Generate new config
-		configBytes, err := json.MarshalIndent(DefaultAppConfig, "", "\t")
-		if err != nil {
-			panic(err)
-		}
-		err = os.WriteFile(res.AppConfigPath, configBytes, 0644)
+		log.Err("[CONFIG] migrate app config failed: %v", err)
+		return nil, err
+	}
+	if from < res.ConfVersion {
+		err = WriteMigratedConfig(res.AppConfigPath, configBytes, migrated)
 		if err != nil {
-			panic(err)
+			log.Err("[CONFIG] write migrated config failed: %v", err)
+			return nil, err
 		}
-		log.Info("[CONFIG] new config generated, edit it and restart the program")
-		os.Exit(0)
+		log.Info("[CONFIG] migrated from v%d to v%d, old config is backed up", from, res.ConfVersion)
+	}
+	return decodeAppConfig(migrated)
+}
+
+// LoadAppConfig reads and decodes the config in path.
+// Old configs are migrated in memory only.
+func LoadAppConfig(path string) (*AppConfig, error) {
+	configBytes, err := os.ReadFile(path)
+	if err != nil {
+		log.Err("[CONFIG] read app config failed: %v", err)
+		return nil, err
+	}
+	migrated, _, err := MigrateConfig(configBytes)
+	if err != nil {
+		log.Err("[CONFIG] migrate app config failed: %v", err)
+		return nil, err
+	}
+	return decodeAppConfig(migrated)
+}
+
+func decodeAppConfig(data []byte) (*AppConfig, error) {
+	config := new(AppConfig)
+	err := json.Unmarshal(data, config)
+	if err != nil {
+		log.Err("[CONFIG] unmarshal app config failed: %v", err)
+		return nil, err
+	}
+	if config.Version > res.ConfVersion {
+		log.Warn("[CONFIG] config v%d is newer than v%d, some fields may be ignored", config.Version, res.ConfVersion)
 	}
-	return err
+	return config, nil
 }
 
-func initInterval() {
-	d, err := time.ParseDuration(Config.Interval)
+// GetInterval returns the check interval,
+// or res.DefaultInterval if c.Interval is invalid.
+func (c *AppConfig) GetInterval() time.Duration {
+	d, err := time.ParseDuration(c.Interval)
 	if err == nil {
 		if d > res.MaxInterval || d < time.Second {
 			log.Warn("[CONFIG] use default interval")
-			CheckInterval = res.DefaultInterval
-			return
+			return res.DefaultInterval
 		}
-		CheckInterval = d
-		return
+		return d
 	}
 	log.Warn("[CONFIG] parse interval failed: %v", err)
-	CheckInterval = res.DefaultInterval
+	return res.DefaultInterval
 }
 
-func initRateLimiter() {
-	splited := strings.Split(Config.Rate, "/")
-	if len(splited) != 2 {
-		log.Warn("[CONFIG] parse rate failed")
-		RateLimiter = res.DefaultRateLimiter
+// GetRateLimiter returns a new limiter for pushes,
+// or a default one if c.Rate is invalid.
+func (c *AppConfig) GetRateLimiter() *rate.RateLimiter[string] {
+	times, duration, err := parseRate(c.Rate)
+	if err != nil {
+		log.Warn("[CONFIG] parse rate failed: %v", err)
+		return rate.NewLimiter[string](res.DefaultRateDuration, res.DefaultRateTimes)
+	}
+	return rate.NewLimiter[string](duration, times)
+}
+
+// GetHistoryRetention returns how long raw values and rollups are kept,
+// defaults are used if they are empty or invalid.
+func (c *AppConfig) GetHistoryRetention() (raw, rollup time.Duration) {
+	raw, rollup = res.DefaultHistoryRaw, res.DefaultHistoryRollup
+	if c.History == nil {
 		return
 	}
+	if d, err := parseRetention(c.History.Raw); err == nil && d > 0 {
+		raw = d
+	}
+	if d, err := parseRetention(c.History.Rollup); err == nil && d > 0 {
+		rollup = d
+	}
+	return
+}
+
+// HistoryDisabled returns whether the history on disk is disabled.
+func (c *AppConfig) HistoryDisabled() bool {
+	return c.History != nil && c.History.Disabled
+}
+
+// Empty means default
+func parseRetention(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("retention should be positive: %s", s)
+	}
+	return d, nil
+}
+
+// eg: "1/1m" -> 1, time.Minute
+func parseRate(s string) (int, time.Duration, error) {
+	splited := strings.Split(s, "/")
+	if len(splited) != 2 {
+		return 0, 0, fmt.Errorf("invalid rate: %s", s)
+	}
 	times, err := strconv.Atoi(splited[0])
 	if err != nil {
-		log.Warn("[CONFIG] parse rate failed: %v", err)
-		RateLimiter = res.DefaultRateLimiter
-		return
+		return 0, 0, err
 	}
 	duration, err := time.ParseDuration(splited[1])
 	if err != nil {
-		log.Warn("[CONFIG] parse rate failed: %v", err)
-		RateLimiter = res.DefaultRateLimiter
-		return
+		return 0, 0, err
+	}
+	if times <= 0 || duration <= 0 {
+		return 0, 0, fmt.Errorf("invalid rate: %s", s)
 	}
-	RateLimiter = rate.NewLimiter[string](duration, times)
+	return times, duration, nil
 }
 
 var (
@@ -149,12 +321,12 @@ var (
 		Code:      200,
 	}
 	defaultWebhookIfaceBytes, _ = json.Marshal(defaultWebhookIface)
-	defaultIosIface = PushIfaceIOS{
-		Token: "",
-		Title: res.PushFormatNameLocator,
-		Content: res.PushFormatMsgLocator,
+	defaultIosIface             = PushIfaceIOS{
+		Token:     "",
+		Title:     res.PushFormatNameLocator,
+		Content:   res.PushFormatMsgLocator,
 		BodyRegex: ".*",
-		Code: 200,
+		Code:      200,
 	}
 	defaultIosIfaceBytes, _ = json.Marshal(defaultIosIface)
 
@@ -172,14 +344,16 @@ var (
 		},
 		Pushes: []Push{
 			{
-				Type:  PushTypeWebhook,
-				Name:  "QQ Group",
-				Iface: defaultWebhookIfaceBytes,
+				Type:     PushTypeWebhook,
+				Name:     "QQ Group",
+				Iface:    defaultWebhookIfaceBytes,
+				Resolved: true,
 			},
 			{
-				Type: PushTypeIOS,
-				Name: "My iPhone",
-				Iface: defaultIosIfaceBytes,
+				Type:     PushTypeIOS,
+				Name:     "My iPhone",
+				Iface:    defaultIosIfaceBytes,
+				Resolved: true,
 			},
 		},
 	}
@@ -0,0 +1,91 @@
+package model_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lollipopkit/server_box_monitor/model"
+)
+
+func TestValidateDefaultConfig(t *testing.T) {
+	if err := model.DefaultAppConfig.Validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestValidateInvalidConfig(t *testing.T) {
+	configs := []*model.AppConfig{
+		{Interval: "1m"},
+		{Rate: "1/0s"},
+		{Rules: []model.Rule{{MonitorType: model.MonitorTypeCPU, Threshold: ">"}}},
+		{Rules: []model.Rule{{MonitorType: "gpu", Threshold: ">1%"}}},
+		{Pushes: []model.Push{{Type: "email", Name: "mail"}}},
+		{Pushes: []model.Push{
+			{Type: model.PushTypeBark, Name: "dup", Iface: []byte("{}")},
+			{Type: model.PushTypeBark, Name: "dup", Iface: []byte("{}")},
+		}},
+	}
+	for i, config := range configs {
+		err := config.Validate()
+		if !errors.Is(err, model.ErrInvalidConfig) {
+			t.Errorf("configs[%d]: expect ErrInvalidConfig, got %v", i, err)
+		}
+		var diags model.ConfigErrors
+		if !errors.As(err, &diags) || len(diags) != 1 {
+			t.Errorf("configs[%d]: expect one ConfigError, got %v", i, err)
+		}
+	}
+}
+
+func TestWriteAppConfig(t *testing.T) {
+	config, err := model.DefaultAppConfig.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Rules[0].Threshold = ">=99%"
+	if model.DefaultAppConfig.Rules[0].Threshold == ">=99%" {
+		t.Fatal("clone should not share rules with the origin")
+	}
+
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte("old"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	// Not affected by umask
+	if err := os.Chmod(path, 0640); err != nil {
+		t.Fatal(err)
+	}
+	// The permission of path is kept
+	if err := model.WriteAppConfig(path, config); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(path); err != nil {
+		t.Fatal(err)
+	} else if info.Mode().Perm() != 0640 {
+		t.Errorf("expect mode 0640, got %v", info.Mode())
+	}
+	loaded, err := model.LoadAppConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Rules[0] != config.Rules[0] || loaded.Name != config.Name {
+		t.Errorf("expect %+v, got %+v", config, loaded)
+	}
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		t.Errorf("temp files left: %v", entries)
+	}
+
+	// New configs are only readable by the owner
+	path = filepath.Join(t.TempDir(), "new.json")
+	if err := model.WriteAppConfig(path, config); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(path); err != nil {
+		t.Fatal(err)
+	} else if info.Mode().Perm() != 0600 {
+		t.Errorf("expect mode 0600, got %v", info.Mode())
+	}
+}
@@ -0,0 +1,257 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/lollipopkit/gommon/util"
+)
+
+var (
+	ErrEmptyExpr           = errors.New("empty expression")
+	ErrMissingOperand      = errors.New("missing operand")
+	ErrUnknownMonitorType  = errors.New("unknown monitor type")
+	ErrUnsupportedUnit     = errors.New("unit not supported by monitor type")
+	ErrUnclosedParenthesis = errors.New("unclosed parenthesis")
+)
+
+// Expr is a boolean expression of comparisons, eg:
+//
+//	cpu > 90% && mem.avail < 500m
+//	net.eth0-in > 10m/s || temp.x86_pkg_temp > 85c
+//	!(disk./ < 90%)
+//
+// Operands are `type.matcher`, the same as Rule.MonitorType and Rule.Matcher.
+// The matcher can be omitted if it's the same as the type, eg: "cpu".
+// `!` > `&&` > `||`, parentheses can be used.
+type Expr interface {
+	// Eval returns the result of the expression and
+	// the values of all comparisons in it.
+	Eval(s *ServerStatus) (bool, []*Value, error)
+	String() string
+}
+
+// ParseExpr parses and type checks s.
+// Errors are *ThresholdError with the position in s.
+func ParseExpr(s string) (Expr, error) {
+	p := newThresholdParser(s)
+	p.skipSpace()
+	if p.eof() {
+		return nil, p.error(ErrEmptyExpr)
+	}
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if !p.eof() {
+		return nil, p.error(ErrUnexpectedSymbol)
+	}
+	return e, nil
+}
+
+func (p *thresholdParser) consume(token string) bool {
+	runes := []rune(token)
+	if p.pos+len(runes) > len(p.runes) {
+		return false
+	}
+	for i, r := range runes {
+		if p.runes[p.pos+i] != r {
+			return false
+		}
+	}
+	p.pos += len(runes)
+	return true
+}
+
+func (p *thresholdParser) parseOr() (Expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		p.skipSpace()
+		if !p.consume("||") {
+			return left, nil
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &exprBinary{and: false, left: left, right: right}
+	}
+}
+
+func (p *thresholdParser) parseAnd() (Expr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		p.skipSpace()
+		if !p.consume("&&") {
+			return left, nil
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &exprBinary{and: true, left: left, right: right}
+	}
+}
+
+func (p *thresholdParser) parseUnary() (Expr, error) {
+	p.skipSpace()
+	if p.consume("!") {
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &exprNot{x: x}, nil
+	}
+	if p.consume("(") {
+		x, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		if !p.consume(")") {
+			return nil, p.error(ErrUnclosedParenthesis)
+		}
+		return x, nil
+	}
+	return p.parseCompare()
+}
+
+// isOperandRune reports whether r can be in `type.matcher`
+func isOperandRune(r rune) bool {
+	return !unicode.IsSpace(r) && !strings.ContainsRune("<>=!()&|", r)
+}
+
+func (p *thresholdParser) parseCompare() (Expr, error) {
+	start := p.pos
+	for !p.eof() && isOperandRune(p.runes[p.pos]) {
+		p.pos++
+	}
+	if p.pos == start {
+		return nil, p.error(ErrMissingOperand)
+	}
+	typ, matcher, found := strings.Cut(string(p.runes[start:p.pos]), ".")
+	matcherStart := start
+	if found {
+		matcherStart += len([]rune(typ)) + 1
+	}
+	if matcher == "" {
+		matcher = typ
+	}
+	c := CollectorOf(MonitorType(typ))
+	if c == nil {
+		p.pos = start
+		return nil, p.error(ErrUnknownMonitorType)
+	}
+	if err := checkMatcher(c, MonitorType(typ), matcher); err != nil {
+		p.pos = matcherStart
+		return nil, p.error(err)
+	}
+
+	p.skipSpace()
+	compareType, err := p.parseCompareType()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	valueStart := p.pos
+	t, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+	t.CompareType = compareType
+	if !util.Contains(c.ThresholdTypes(MonitorType(typ)), t.ThresholdType) {
+		p.pos = valueStart
+		return nil, p.error(ErrUnsupportedUnit)
+	}
+	return &exprCompare{
+		typ:       MonitorType(typ),
+		matcher:   matcher,
+		threshold: t,
+		collector: c,
+	}, nil
+}
+
+type exprCompare struct {
+	typ       MonitorType
+	matcher   string
+	threshold *Threshold
+	collector Collector
+}
+
+func (e *exprCompare) Eval(s *ServerStatus) (bool, []*Value, error) {
+	v, err := e.collector.Value(s, e.typ, e.matcher, e.threshold.ThresholdType)
+	if err != nil {
+		return false, nil, err
+	}
+	ok, err := e.threshold.True(v.Value)
+	if err != nil {
+		return false, nil, err
+	}
+	return ok, []*Value{v}, nil
+}
+
+func (e *exprCompare) String() string {
+	return fmt.Sprintf("%s.%s %s", e.typ, e.matcher, e.threshold)
+}
+
+type exprBinary struct {
+	// && if true, || otherwise
+	and         bool
+	left, right Expr
+}
+
+// Eval doesn't short circuit, so all values are in the push message.
+// If one side fails, such as a device is not ready or gone,
+// the result is still known when the other side decides it:
+// true for `||` and false for `&&`.
+func (e *exprBinary) Eval(s *ServerStatus) (bool, []*Value, error) {
+	left, leftValues, leftErr := e.left.Eval(s)
+	right, rightValues, rightErr := e.right.Eval(s)
+	switch {
+	case leftErr == nil && rightErr == nil:
+		values := make([]*Value, 0, len(leftValues)+len(rightValues))
+		values = append(values, leftValues...)
+		values = append(values, rightValues...)
+		if e.and {
+			return left && right, values, nil
+		}
+		return left || right, values, nil
+	case leftErr == nil && left != e.and:
+		return left, leftValues, nil
+	case rightErr == nil && right != e.and:
+		return right, rightValues, nil
+	case leftErr != nil:
+		return false, nil, leftErr
+	}
+	return false, nil, rightErr
+}
+
+func (e *exprBinary) String() string {
+	op := "||"
+	if e.and {
+		op = "&&"
+	}
+	return fmt.Sprintf("(%s %s %s)", e.left, op, e.right)
+}
+
+type exprNot struct {
+	x Expr
+}
+
+func (e *exprNot) Eval(s *ServerStatus) (bool, []*Value, error) {
+	ok, values, err := e.x.Eval(s)
+	return !ok, values, err
+}
+
+func (e *exprNot) String() string {
+	return fmt.Sprintf("!%s", e.x)
+}
@@ -0,0 +1,120 @@
+package model_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/lollipopkit/server_box_monitor/model"
+)
+
+func TestParseExpr(t *testing.T) {
+	cases := map[string]string{
+		"cpu > 90%":                 "cpu.cpu >90%",
+		"cpu>90% && mem.avail<500m": "(cpu.cpu >90% && mem.avail <524288000b)",
+		"a || b && c":               "",
+		"net.eth0-in > 10m/s || !(temp.x86_pkg_temp <= 85c)": "(net.eth0-in >10485760b/s || !temp.x86_pkg_temp <=85c)",
+		"(cpu > 1% || swap.used > 1%) && disk./ >= 1%":       "((cpu.cpu >1% || swap.used >1%) && disk./ >=1%)",
+	}
+	for input, expect := range cases {
+		e, err := model.ParseExpr(input)
+		if expect == "" {
+			if err == nil {
+				t.Errorf("%q: expect error", input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", input, err)
+			continue
+		}
+		if e.String() != expect {
+			t.Errorf("%q: expect %s, got %s", input, expect, e)
+		}
+	}
+}
+
+func TestParseExprError(t *testing.T) {
+	cases := []struct {
+		input string
+		err   error
+		pos   int
+	}{
+		{"", model.ErrEmptyExpr, 0},
+		{"gpu > 1%", model.ErrUnknownMonitorType, 0},
+		{"cpu > 1% &&", model.ErrMissingOperand, 11},
+		{"cpu > 1m", model.ErrUnsupportedUnit, 6},
+		{"temp.x86_pkg_temp > 1% || cpu > 1%", model.ErrUnsupportedUnit, 20},
+		{"(cpu > 1%", model.ErrUnclosedParenthesis, 9},
+		{"cpu 1%", model.ErrMissingOperator, 4},
+		{"cpu > 1% cpu", model.ErrUnexpectedSymbol, 9},
+		{"cpu > 1% && mem.availible < 1g", model.ErrUnknownMatcher, 16},
+		{"mem < 1g", model.ErrUnknownMatcher, 0},
+	}
+	for _, c := range cases {
+		_, err := model.ParseExpr(c.input)
+		var exprErr *model.ThresholdError
+		if !errors.As(err, &exprErr) || !errors.Is(err, c.err) || exprErr.Pos != c.pos {
+			t.Errorf("%q: expect %v at %d, got %v", c.input, c.err, c.pos, err)
+		}
+	}
+}
+
+func TestRuleExpr(t *testing.T) {
+	s := new(model.ServerStatus)
+	if err := s.Refresh(_testHostFS(t)); err != nil {
+		t.Fatal(err)
+	}
+	rule := model.Rule{Expr: "temp.x86_pkg_temp > 40c && mem.free < 1g"}
+	notify, pair, err := rule.ShouldNotify(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !notify {
+		t.Errorf("%s: expect notify", rule.Id())
+	}
+	msg := model.PushFormat("{{msg}}").Format("", []*model.PushPair{pair}, false)
+	expect := "temp.x86_pkg_temp > 40c && mem.free < 1g: x86_pkg_temp: 45.00°C, Mem free: "
+	if len(msg) <= len(expect) || msg[:len(expect)] != expect {
+		t.Errorf("expect all values in the message, got %q", msg)
+	}
+
+	rule = model.Rule{Expr: "temp.x86_pkg_temp > 40c && !(mem.free < 1g)"}
+	notify, _, err = rule.ShouldNotify(s)
+	if err != nil || notify {
+		t.Errorf("%s: expect not notify, got %v %v", rule.Id(), notify, err)
+	}
+}
+
+func TestRuleExprNotReady(t *testing.T) {
+	s := new(model.ServerStatus)
+	if err := s.Refresh(_testHostFS(t)); err != nil {
+		t.Fatal(err)
+	}
+	// Speeds need two ticks, the other side decides the result
+	cases := map[string]bool{
+		"net.eth0-in > 1m/s || mem.used > 1%":    true,
+		"mem.used < 1% && net.eth0-in > 1m/s":    false,
+		"!(mem.used < 1% && net.eth0-in > 1m/s)": true,
+	}
+	for expr, expect := range cases {
+		rule := model.Rule{Expr: expr}
+		notify, pair, err := rule.ShouldNotify(s)
+		if err != nil || notify != expect {
+			t.Errorf("%s: expect %v, got %v %v", expr, expect, notify, err)
+		}
+		if notify && !strings.Contains(pair.String(), "Mem used") {
+			t.Errorf("%s: expect the value of the known side, got %s", expr, pair)
+		}
+	}
+
+	for _, expr := range []string{
+		"net.eth0-in > 1m/s || mem.used < 1%",
+		"net.eth0-in > 1m/s && mem.used > 1%",
+	} {
+		rule := model.Rule{Expr: expr}
+		if _, _, err := rule.ShouldNotify(s); !errors.Is(err, model.ErrNotReady) {
+			t.Errorf("%s: expect ErrNotReady, got %v", expr, err)
+		}
+	}
+}
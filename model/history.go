@@ -0,0 +1,169 @@
+package model
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/lollipopkit/server_box_monitor/res"
+)
+
+// History keeps recent values of all metrics in memory,
+// it's used by rules which look back in a window.
+type History struct {
+	// MetricKey -> samples
+	rings map[string]*Ring[float64]
+	lock  sync.Mutex
+}
+
+func NewHistory() *History {
+	return &History{rings: map[string]*Ring[float64]{}}
+}
+
+// MetricKey returns the key of a metric in History.
+// eg: "cpu/cpu0/percent" "net/eth0-in/speed"
+func MetricKey(typ MonitorType, matcher string, tt ThresholdType) string {
+	return fmt.Sprintf("%s/%s/%s", typ, matcher, tt.Name())
+}
+
+// RecordStatus records all values in s which collectors provide,
+// and drops the metrics which are gone for res.MaxWindow.
+// It returns the recorded values by MetricKey.
+func (h *History) RecordStatus(s *ServerStatus) map[string]float64 {
+	recorded := map[string]float64{}
+	for _, c := range collectors {
+		matchers := c.Matchers(s)
+		for _, typ := range c.Types() {
+			for _, tt := range c.ThresholdTypes(typ) {
+				for _, matcher := range matchers {
+					v, err := c.Value(s, typ, matcher, tt)
+					if err != nil {
+						continue
+					}
+					key := MetricKey(typ, matcher, tt)
+					h.Record(key, v.Value, s.Time)
+					recorded[key] = v.Value
+				}
+			}
+		}
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for key, ring := range h.rings {
+		last, ok := ring.Last(0)
+		if !ok || s.Time.Sub(last.Time) > res.MaxWindow {
+			delete(h.rings, key)
+		}
+	}
+	return recorded
+}
+
+// Record adds value of key at now.
+// Several rules can record one metric in one tick, the last one is kept.
+func (h *History) Record(key string, value float64, now time.Time) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	ring, ok := h.rings[key]
+	if !ok {
+		ring = NewRing[float64](res.HistoryCapacity)
+		h.rings[key] = ring
+	}
+	if last, ok := ring.Last(0); ok && !last.Time.Before(now) {
+		ring.SetLast(value)
+		return
+	}
+	ring.Push(now, value)
+}
+
+// Window returns the samples of key in window before now.
+func (h *History) Window(key string, now time.Time, window time.Duration) Samples {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	ring, ok := h.rings[key]
+	if !ok {
+		return nil
+	}
+	return ring.Since(now.Add(-window), false)
+}
+
+// Aggregate returns the aggregated value of key in the window of a.
+// It returns ErrNotReady if the samples don't cover the window yet.
+func (h *History) Aggregate(key string, now time.Time, a Aggregate) (float64, error) {
+	samples, err := h.since(key, now, a.Window)
+	if err != nil {
+		return 0, err
+	}
+	// The first one is at or before the window
+	return a.Apply(samples[1:])
+}
+
+// Range returns the samples of key in [from, to].
+func (h *History) Range(key string, from, to time.Time) Samples {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	ring, ok := h.rings[key]
+	if !ok {
+		return nil
+	}
+	samples := Samples{}
+	for _, sample := range ring.Since(from.Add(-1), false) {
+		if sample.Time.After(to) {
+			break
+		}
+		samples = append(samples, sample)
+	}
+	return samples
+}
+
+// since returns the samples from the newest one at or before now - window.
+// It returns ErrNotReady if the samples don't cover window yet.
+func (h *History) since(key string, now time.Time, window time.Duration) (Samples, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	ring, ok := h.rings[key]
+	if !ok {
+		return nil, ErrNotReady
+	}
+	cut := now.Add(-window)
+	samples := ring.Since(cut, true)
+	if len(samples) < 2 || samples[0].Time.After(cut) {
+		return nil, ErrNotReady
+	}
+	return samples, nil
+}
+
+// Change returns how much key changed in window before now.
+// It returns ErrNotReady if there is no sample old enough.
+func (h *History) Change(key string, now time.Time, window time.Duration) (float64, error) {
+	samples, err := h.since(key, now, window)
+	if err != nil {
+		return 0, err
+	}
+	return samples[len(samples)-1].Value - samples[0].Value, nil
+}
+
+// Slope returns how fast key changes per second in window before now,
+// by the linear regression of the samples.
+// It returns ErrNotReady if the samples don't cover window yet.
+func (h *History) Slope(key string, now time.Time, window time.Duration) (float64, error) {
+	samples, err := h.since(key, now, window)
+	if err != nil {
+		return 0, err
+	}
+	// Least squares, x is seconds since the first sample
+	var sumX, sumY, sumXY, sumXX float64
+	for _, sample := range samples {
+		x := sample.Time.Sub(samples[0].Time).Seconds()
+		sumX += x
+		sumY += sample.Value
+		sumXY += x * sample.Value
+		sumXX += x * x
+	}
+	n := float64(len(samples))
+	denominator := n*sumXX - sumX*sumX
+	if denominator == 0 {
+		return 0, ErrNotReady
+	}
+	return (n*sumXY - sumX*sumY) / denominator, nil
+}
@@ -0,0 +1,88 @@
+package model_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lollipopkit/server_box_monitor/model"
+)
+
+func TestHistoryChange(t *testing.T) {
+	h := model.NewHistory()
+	start := time.Unix(0, 0)
+	window := 10 * time.Minute
+	for i := 0; i <= 15; i++ {
+		now := start.Add(time.Duration(i) * time.Minute)
+		h.Record("k", float64(i*i), now)
+		change, err := h.Change("k", now, window)
+		if i < 10 {
+			if !errors.Is(err, model.ErrNotReady) {
+				t.Errorf("minute %d: expect ErrNotReady, got %v", i, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expect := float64(i*i - (i-10)*(i-10)); change != expect {
+			t.Errorf("minute %d: expect %v, got %v", i, expect, change)
+		}
+	}
+}
+
+func TestRuleCheckChange(t *testing.T) {
+	s := new(model.ServerStatus)
+	if err := s.Refresh(_testHostFS(t)); err != nil {
+		t.Fatal(err)
+	}
+	h := model.NewHistory()
+	rule := model.Rule{
+		MonitorType: model.MonitorTypeMemory,
+		Matcher:     "used",
+		Threshold:   ">100m",
+		Mode:        model.RuleModeChange,
+		Window:      "5m",
+	}
+	if _, err := rule.Check(s, h); !errors.Is(err, model.ErrNotReady) {
+		t.Fatalf("expect ErrNotReady at the first tick, got %v", err)
+	}
+
+	later := s.Clone()
+	later.Time = s.Time.Add(5 * time.Minute)
+	later.Mem.Used += 200 * 1024 * 1024
+	result, err := rule.Check(later, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Reached || result.Cleared {
+		t.Errorf("%s: expect reached, got %+v", rule.Id(), result)
+	}
+	msg := model.PushFormat("{{msg}}").Format("", []*model.PushPair{result.PushPair}, false)
+	if expect := "Mem used change in 5m: 200.0m"; msg != expect {
+		t.Errorf("expect %q, got %q", expect, msg)
+	}
+}
+
+func TestHistoryRecordStatus(t *testing.T) {
+	s := new(model.ServerStatus)
+	if err := s.Refresh(_testHostFS(t)); err != nil {
+		t.Fatal(err)
+	}
+	h := model.NewHistory()
+	h.RecordStatus(s)
+	for _, key := range []string{
+		"mem/used/percent",
+		"swap/free/size",
+		"temp/x86_pkg_temp/temperature",
+		"net/eth0-in/size",
+	} {
+		if samples := h.Window(key, s.Time, time.Minute); len(samples) != 1 {
+			t.Errorf("%s: expect 1 sample, got %d", key, len(samples))
+		}
+	}
+	// Needs two samples
+	if samples := h.Window("cpu/cpu/percent", s.Time, time.Minute); len(samples) != 0 {
+		t.Errorf("expect no cpu sample, got %d", len(samples))
+	}
+}
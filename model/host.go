@@ -0,0 +1,202 @@
+package model
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lollipopkit/gommon/log"
+	"github.com/lollipopkit/gommon/util"
+)
+
+// HostFS tells the collectors where to read the host status from.
+// Point it at a fixture tree in tests, or at the host's /proc, /sys
+// and / mounted into a container.
+type HostFS struct {
+	// eg: "/proc"
+	Proc string
+	// eg: "/sys"
+	Sys string
+	// Prefix joined to every mount point before statfs.
+	// eg: "/" "/host"
+	Root string
+}
+
+var (
+	DefaultHostFS = &HostFS{
+		Proc: "/proc",
+		Sys:  "/sys",
+		Root: "/",
+	}
+)
+
+// NewHostFS returns the HostFS of the host whose / is mounted at root,
+// eg: "/host" in a container run with `-v /:/host:ro`.
+// DefaultHostFS is returned if root is empty or "/".
+func NewHostFS(root string) *HostFS {
+	if root == "" || filepath.Clean(root) == "/" {
+		return DefaultHostFS
+	}
+	return &HostFS{
+		Proc: filepath.Join(root, "proc"),
+		Sys:  filepath.Join(root, "sys"),
+		Root: root,
+	}
+}
+
+// Filesystems which never hold user data, `df` hides them too.
+var pseudoFilesystems = []string{
+	"autofs", "binfmt_misc", "bpf", "cgroup", "cgroup2", "configfs",
+	"debugfs", "devpts", "fusectl", "hugetlbfs", "mqueue", "nsfs",
+	"proc", "pstore", "rpc_pipefs", "securityfs", "selinuxfs", "sysfs",
+	"tracefs",
+}
+
+func (fs *HostFS) proc(elem ...string) string {
+	return filepath.Join(append([]string{fs.Proc}, elem...)...)
+}
+func (fs *HostFS) sys(elem ...string) string {
+	return filepath.Join(append([]string{fs.Sys}, elem...)...)
+}
+
+func (fs *HostFS) ReadNetworkStatus(ss *ServerStatus) error {
+	data, err := os.ReadFile(fs.proc("net", "dev"))
+	if err != nil {
+		return err
+	}
+	return ss.ParseNetworkStatus(string(data))
+}
+
+func (fs *HostFS) ReadCPUStatus(ss *ServerStatus) error {
+	data, err := os.ReadFile(fs.proc("stat"))
+	if err != nil {
+		return err
+	}
+	// Only `cpu` and `cpuN` lines, same as `grep cpu`
+	lines := []string{}
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(line, "cpu") {
+			lines = append(lines, line)
+		}
+	}
+	return ss.ParseCPUStatus(strings.Join(lines, "\n"))
+}
+
+func (fs *HostFS) ReadMeminfo() (string, error) {
+	data, err := os.ReadFile(fs.proc("meminfo"))
+	return string(data), err
+}
+
+func (fs *HostFS) ReadDiskStatus(ss *ServerStatus) error {
+	file, err := os.Open(fs.proc("mounts"))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	disks := []diskStatus{}
+	seen := map[string]bool{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// eg: "/dev/sda1 / ext4 rw,relatime 0 0"
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 {
+			continue
+		}
+		filesystem := unescapeMountField(fields[0])
+		mountPath := unescapeMountField(fields[1])
+		fsType := fields[2]
+		if seen[mountPath] || util.Contains(pseudoFilesystems, fsType) {
+			continue
+		}
+		seen[mountPath] = true
+
+		total, free, avail, err := statfs(filepath.Join(fs.Root, mountPath))
+		if err != nil {
+			log.Debug("[STATUS] statfs %s failed: %s", mountPath, err)
+			continue
+		}
+		// Such as `/proc/sys/fs/binfmt_misc` which is not in the list above
+		if total == 0 {
+			continue
+		}
+		used := total - free
+		disks = append(disks, diskStatus{
+			MountPath:   mountPath,
+			Filesystem:  filesystem,
+			Total:       total,
+			Used:        used,
+			Avail:       avail,
+			UsedPercent: float64(used) / float64(total) * 100,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	ss.Disk = disks
+	return nil
+}
+
+func (fs *HostFS) ReadTemperatureStatus(ss *ServerStatus) error {
+	zones, err := filepath.Glob(fs.sys("class", "thermal", "thermal_zone*"))
+	if err != nil {
+		return err
+	}
+	// thermal_zone10 should be after thermal_zone9
+	sort.Slice(zones, func(i, j int) bool {
+		if len(zones[i]) != len(zones[j]) {
+			return len(zones[i]) < len(zones[j])
+		}
+		return zones[i] < zones[j]
+	})
+
+	temps := make([]temperatureStatus, 0, len(zones))
+	for _, zone := range zones {
+		typ, err := os.ReadFile(filepath.Join(zone, "type"))
+		if err != nil {
+			return err
+		}
+		temp, err := os.ReadFile(filepath.Join(zone, "temp"))
+		if err != nil {
+			// Some zones can't be read when the sensor is off
+			log.Debug("[STATUS] read %s failed: %s", zone, err)
+			continue
+		}
+		value, err := strconv.ParseFloat(strings.TrimSpace(string(temp)), 64)
+		if err != nil {
+			return errors.Join(ErrInvalidStatus, fmt.Errorf("invalid temperature: %s", temp))
+		}
+		temps = append(temps, temperatureStatus{
+			Name:  strings.TrimSpace(string(typ)),
+			Zone:  filepath.Base(zone),
+			Value: value / 1000,
+		})
+	}
+	ss.Temperature = temps
+	return nil
+}
+
+// Spaces, tabs and backslashes are escaped as octal in /proc/mounts.
+// eg: "/mnt/data\040disk" -> "/mnt/data disk"
+func unescapeMountField(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if v, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				sb.WriteByte(byte(v))
+				i += 3
+				continue
+			}
+		}
+		sb.WriteByte(s[i])
+	}
+	return sb.String()
+}
@@ -0,0 +1,242 @@
+package model_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lollipopkit/server_box_monitor/model"
+)
+
+func _testHostFS(t *testing.T) *model.HostFS {
+	root := t.TempDir()
+	for _, dir := range []string{"boot", "mnt/data disk"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &model.HostFS{
+		Proc: "test/proc",
+		Sys:  "test/sys",
+		Root: root,
+	}
+}
+
+func TestReadCPUStatus(t *testing.T) {
+	s := new(model.ServerStatus)
+	fs := _testHostFS(t)
+	if err := fs.ReadCPUStatus(s); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.CPU) != 3 {
+		t.Fatalf("expect 3 cpu lines, got %d", len(s.CPU))
+	}
+	sample, _ := s.CPU[1].Samples.Last(0)
+	if sample.Value.Total != 1393280+32966+572056+13343292+6130+0+17875 {
+		t.Errorf("unexpected cpu0 total: %d", sample.Value.Total)
+	}
+}
+
+func TestReadMeminfo(t *testing.T) {
+	s := new(model.ServerStatus)
+	fs := _testHostFS(t)
+	meminfo, err := fs.ReadMeminfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ParseMemStatus(meminfo); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ParseSwapStatus(meminfo); err != nil {
+		t.Fatal(err)
+	}
+	mem := s.Mem
+	if mem.Total != 8048072*1024 || mem.Avail != 4863212*1024 {
+		t.Errorf("unexpected mem: %+v", mem)
+	}
+	if mem.Used != mem.Total-mem.Avail {
+		t.Errorf("unexpected mem used: %s", mem.Used)
+	}
+	swap := s.Swap
+	if swap.Used != (2097148-1855484)*1024 || swap.Cached != 8704*1024 {
+		t.Errorf("unexpected swap: %+v", swap)
+	}
+}
+
+func TestReadNetworkStatus(t *testing.T) {
+	s := new(model.ServerStatus)
+	fs := _testHostFS(t)
+	if err := fs.ReadNetworkStatus(s); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Network) != 2 {
+		t.Fatalf("expect 2 interfaces, got %d", len(s.Network))
+	}
+	eth0 := s.Network[1]
+	if eth0.Interface != "eth0" {
+		t.Errorf("expect eth0, got %s", eth0.Interface)
+	}
+	if eth0.Receive() != 1209472863 || eth0.Transmit() != 86574205 {
+		t.Errorf("unexpected eth0 counters: %s / %s", eth0.Receive(), eth0.Transmit())
+	}
+}
+
+func TestReadDiskStatus(t *testing.T) {
+	s := new(model.ServerStatus)
+	fs := _testHostFS(t)
+	if err := fs.ReadDiskStatus(s); err != nil {
+		t.Fatal(err)
+	}
+	mounts := []string{}
+	for _, d := range s.Disk {
+		mounts = append(mounts, d.MountPath)
+		if d.Total == 0 || d.Used > d.Total {
+			t.Errorf("unexpected disk: %+v", d)
+		}
+	}
+	expect := []string{"/", "/boot", "/mnt/data disk"}
+	if len(mounts) != len(expect) {
+		t.Fatalf("expect %v, got %v", expect, mounts)
+	}
+	for i := range expect {
+		if mounts[i] != expect[i] {
+			t.Errorf("expect %v, got %v", expect, mounts)
+		}
+	}
+}
+
+// Mounts of the host which `df` listed in the old test/disk fixture
+func TestReadDiskStatusDocker(t *testing.T) {
+	expect := []struct{ mount, filesystem string }{
+		{"/dev", "devtmpfs"},
+		{"/dev/shm", "tmpfs"},
+		{"/run", "tmpfs"},
+		{"/sys/fs/cgroup", "tmpfs"},
+		{"/", "/dev/mapper/centosvolume-root"},
+		{"/boot", "/dev/sda2"},
+		{"/boot/efi", "/dev/sda1"},
+		{"/var/lib/docker/overlay2/2e25140c8e4d7cb41e50a5ee1ff9bec57f78f4d2153a89924911f14d946e2dff/merged", "overlay"},
+		{"/var/lib/docker/containers/859a6f0583b00d476dbdb901a78ca0db43d8e32261a719612bd52275ae89b36c/mounts/shm", "shm"},
+		{"/var/lib/docker/overlay2/9bb79d2a05069c0fd8b7d1ac3fecd4bde7aefc020062f8eb06b27a096874e5f2/merged", "overlay"},
+		{"/run/user/0", "tmpfs"},
+	}
+	root := t.TempDir()
+	for _, e := range expect {
+		if err := os.MkdirAll(filepath.Join(root, e.mount), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fs := &model.HostFS{Proc: "test/disk/proc", Sys: "test/sys", Root: root}
+	s := new(model.ServerStatus)
+	if err := fs.ReadDiskStatus(s); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Disk) != len(expect) {
+		t.Fatalf("expect %d disks, got %+v", len(expect), s.Disk)
+	}
+	for i, d := range s.Disk {
+		if d.MountPath != expect[i].mount || d.Filesystem != expect[i].filesystem {
+			t.Errorf("disk %d: expect %s on %s, got %s on %s", i, expect[i].filesystem, expect[i].mount, d.Filesystem, d.MountPath)
+		}
+		if d.Total == 0 || d.Used > d.Total || d.Avail > d.Total-d.Used || d.UsedPercent < 0 || d.UsedPercent > 100 {
+			t.Errorf("%s: unexpected sizes: %+v", d.MountPath, d)
+		}
+	}
+}
+
+func TestNewHostFS(t *testing.T) {
+	if fs := model.NewHostFS("/"); fs != model.DefaultHostFS {
+		t.Errorf("expect DefaultHostFS, got %+v", fs)
+	}
+	fs := model.NewHostFS("/host")
+	if fs.Proc != "/host/proc" || fs.Sys != "/host/sys" || fs.Root != "/host" {
+		t.Errorf("unexpected host fs: %+v", fs)
+	}
+}
+
+func TestReadTemperatureStatus(t *testing.T) {
+	s := new(model.ServerStatus)
+	fs := _testHostFS(t)
+	if err := fs.ReadTemperatureStatus(s); err != nil {
+		t.Fatal(err)
+	}
+	temps := s.Temperature
+	if len(temps) != 3 {
+		t.Fatalf("expect 3 zones, got %d", len(temps))
+	}
+	if temps[1].Name != "x86_pkg_temp" || temps[1].Zone != "thermal_zone1" || temps[1].Value != 45 {
+		t.Errorf("unexpected zone: %+v", temps[1])
+	}
+}
+
+func TestTemperatureMatcher(t *testing.T) {
+	s := new(model.ServerStatus)
+	if err := _testHostFS(t).ReadTemperatureStatus(s); err != nil {
+		t.Fatal(err)
+	}
+	c := model.CollectorOf(model.MonitorTypeTemperature)
+	cases := map[string]float64{
+		"thermal_zone2": 52,
+		"x86_pkg_temp":  45,
+		// Not a substring match
+		"x86_pkg": 0,
+		// thermal_zone0 and thermal_zone2
+		"acpitz": 0,
+	}
+	for matcher, expect := range cases {
+		v, err := c.Value(s, model.MonitorTypeTemperature, matcher, model.ThresholdTypeTemperature)
+		if expect == 0 {
+			if !errors.Is(err, model.ErrInvalidRule) {
+				t.Errorf("%s: expect ErrInvalidRule, got %v %v", matcher, v, err)
+			}
+			continue
+		}
+		if err != nil || v.Value != expect {
+			t.Errorf("%s: expect %v, got %v %v", matcher, expect, v, err)
+		}
+	}
+	matchers := strings.Join(c.Matchers(s), " ")
+	if expect := "thermal_zone0 thermal_zone1 x86_pkg_temp thermal_zone2"; matchers != expect {
+		t.Errorf("expect matchers %q, got %q", expect, matchers)
+	}
+}
+
+func TestNetworkMatcher(t *testing.T) {
+	s := new(model.ServerStatus)
+	err := s.ParseNetworkStatus(`Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+  wlo1: 1 0 0 0 0 0 0 0 2 0 0 0 0 0 0 0
+    lo: 10 0 0 0 0 0 0 0 20 0 0 0 0 0 0 0
+veth0a1b: 100 0 0 0 0 0 0 0 200 0 0 0 0 0 0 0
+  eth0: 1000 0 0 0 0 0 0 0 2000 0 0 0 0 0 0 0
+br-int: 10000 0 0 0 0 0 0 0 20000 0 0 0 0 0 0 0`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := model.CollectorOf(model.MonitorTypeNetwork)
+	cases := map[string]float64{
+		"lo":         30,
+		"lo-in":      10,
+		"eth0-out":   2000,
+		"veth0a1b":   300,
+		"br-int":     30000,
+		"br-int-in":  10000,
+		"br-int-out": 20000,
+		"eth":        0,
+		"eth0-up":    0,
+	}
+	for matcher, expect := range cases {
+		v, err := c.Value(s, model.MonitorTypeNetwork, matcher, model.ThresholdTypeSize)
+		if expect == 0 {
+			if !errors.Is(err, model.ErrInvalidRule) {
+				t.Errorf("%s: expect ErrInvalidRule, got %v %v", matcher, v, err)
+			}
+			continue
+		}
+		if err != nil || v.Value != expect {
+			t.Errorf("%s: expect %v, got %v %v", matcher, expect, v, err)
+		}
+	}
+}
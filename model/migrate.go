@@ -0,0 +1,120 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/lollipopkit/server_box_monitor/res"
+)
+
+var (
+	ErrMigrateConfig = errors.New("migrate config failed")
+)
+
+// migration converts a config of version N to N+1.
+// It works on the raw JSON object, so fields which are
+// renamed or removed in AppConfig can still be read.
+type migration func(config map[string]any) error
+
+// Key is the version to migrate from.
+// Add a new one here every time res.ConfVersion is bumped.
+var migrations = map[int]migration{
+	1: migrateV1ToV2,
+}
+
+// MigrateConfig converts the config file content data to res.ConfVersion,
+// rules, pushes and keys unknown to AppConfig are kept.
+// The result is in the format of NormalizeConfig.
+// It returns the version of data, and data itself if it's up to date.
+func MigrateConfig(data []byte) ([]byte, int, error) {
+	raw, err := decodeRawConfig(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	from := configVersion(raw)
+	if from >= res.ConfVersion {
+		return data, from, nil
+	}
+
+	for version := from; version < res.ConfVersion; version++ {
+		migrate, ok := migrations[version]
+		if !ok {
+			return nil, from, errors.Join(ErrMigrateConfig, fmt.Errorf("no migration from v%d", version))
+		}
+		err = migrate(raw)
+		if err != nil {
+			return nil, from, errors.Join(ErrMigrateConfig, fmt.Errorf("v%d -> v%d: %w", version, version+1, err))
+		}
+		raw["version"] = version + 1
+	}
+
+	// Not through AppConfig, or keys it doesn't know are dropped,
+	// eg: the ones of a newer version
+	migrated, err := encodeConfig(raw)
+	if err != nil {
+		return nil, from, err
+	}
+	err = json.Unmarshal(migrated, new(AppConfig))
+	if err != nil {
+		return nil, from, errors.Join(ErrMigrateConfig, err)
+	}
+	return migrated, from, nil
+}
+
+// NormalizeConfig returns data in the format of the config file,
+// with the keys of objects sorted, so it can be diffed with the migrated one.
+func NormalizeConfig(data []byte) ([]byte, error) {
+	raw, err := decodeRawConfig(data)
+	if err != nil {
+		return nil, err
+	}
+	return encodeConfig(raw)
+}
+
+// Numbers are kept as they are, not converted to float64.
+func decodeRawConfig(data []byte) (map[string]any, error) {
+	raw := map[string]any{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	err := dec.Decode(&raw)
+	if err != nil {
+		return nil, err
+	}
+	return raw, nil
+}
+
+// Configs without `version` are treated as v1.
+func configVersion(raw map[string]any) int {
+	number, ok := raw["version"].(json.Number)
+	if !ok {
+		return 1
+	}
+	version, err := number.Int64()
+	if err != nil || version < 1 {
+		return 1
+	}
+	return int(version)
+}
+
+// v2 requires `interval`, `rate` and `name`,
+// v1 configs may not have them.
+func migrateV1ToV2(config map[string]any) error {
+	defaults := map[string]string{
+		"interval": res.DefaultIntervalStr,
+		"rate":     res.DefaultRateStr,
+		"name":     res.DefaultSeverName,
+	}
+	for key, value := range defaults {
+		if v, ok := config[key].(string); !ok || v == "" {
+			config[key] = value
+		}
+	}
+	for _, key := range []string{"rules", "pushes"} {
+		if _, ok := config[key]; !ok {
+			config[key] = []any{}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package model_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/lollipopkit/server_box_monitor/model"
+	"github.com/lollipopkit/server_box_monitor/res"
+)
+
+const _v1Config = `{
+	"interval": "5s",
+	"rules": [{"type": "cpu", "threshold": ">=80%", "matcher": "cpu"}],
+	"pushes": [{"type": "bark", "name": "phone", "iface": {"key": "abc"}}]
+}`
+
+func TestMigrateConfig(t *testing.T) {
+	migrated, from, err := model.MigrateConfig([]byte(_v1Config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from != 1 {
+		t.Errorf("expect from v1, got v%d", from)
+	}
+	config := new(model.AppConfig)
+	if err := json.Unmarshal(migrated, config); err != nil {
+		t.Fatal(err)
+	}
+	if config.Version != res.ConfVersion {
+		t.Errorf("expect v%d, got v%d", res.ConfVersion, config.Version)
+	}
+	if config.Interval != "5s" || config.Rate != res.DefaultRateStr || config.Name != res.DefaultSeverName {
+		t.Errorf("unexpected migrated config: %+v", config)
+	}
+	if len(config.Rules) != 1 || config.Rules[0].Threshold != ">=80%" {
+		t.Errorf("rules should be kept, got %+v", config.Rules)
+	}
+	if len(config.Pushes) != 1 || config.Pushes[0].Name != "phone" {
+		t.Errorf("pushes should be kept, got %+v", config.Pushes)
+	}
+	if err := config.Validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMigrateConfigUpToDate(t *testing.T) {
+	data, err := model.EncodeAppConfig(model.DefaultAppConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	migrated, from, err := model.MigrateConfig(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from != res.ConfVersion || string(migrated) != string(data) {
+		t.Error("up to date config should not be changed")
+	}
+}
+
+func TestMigrateConfigKeepsUnknownKeys(t *testing.T) {
+	migrated, _, err := model.MigrateConfig([]byte(`{
+		"rules": [],
+		"future": {"id": 9007199254740993}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(migrated), `"future": {`) || !strings.Contains(string(migrated), "9007199254740993") {
+		t.Errorf("unknown keys should be kept, got %s", migrated)
+	}
+
+	// Only the changes of the migration are left after normalizing
+	old, err := model.NormalizeConfig([]byte(_v1Config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	migrated, _, err = model.MigrateConfig([]byte(_v1Config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	added := []string{}
+	for _, line := range strings.Split(string(migrated), "\n") {
+		if !strings.Contains(string(old), line) {
+			added = append(added, strings.TrimSpace(line))
+		}
+	}
+	expect := []string{`"name": "` + res.DefaultSeverName + `",`, `"rate": "` + res.DefaultRateStr + `",`, `"version": 2`}
+	if strings.Join(added, "\n") != strings.Join(expect, "\n") {
+		t.Errorf("expect %q added, got %q", expect, added)
+	}
+}
@@ -0,0 +1,64 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// eg: "disk / full within 6h" is
+// {"type": "disk", "matcher": "/", "mode": "predict", "window": "1h", "within": "6h"}
+//
+// The used size in window is fitted by linear regression,
+// then the time left is how long it takes to reach the total.
+func (r *Rule) checkPredict(s *ServerStatus, h *History) (*RuleResult, error) {
+	if r.MonitorType != MonitorTypeDisk {
+		return nil, errors.Join(ErrInvalidRule, fmt.Errorf("predict is not supported by %s", r.MonitorType))
+	}
+	window, err := r.GetWindow()
+	if err != nil {
+		return nil, err
+	}
+	within, err := r.GetWithin()
+	if err != nil {
+		return nil, err
+	}
+	disk, err := findDisk(s, r.Matcher)
+	if err != nil {
+		return nil, err
+	}
+	key := MetricKey(r.MonitorType, r.Matcher, ThresholdTypeSize)
+	h.Record(key, float64(disk.Used), s.Time)
+	// Bytes per second
+	slope, err := h.Slope(key, s.Time, window)
+	if err != nil {
+		return nil, err
+	}
+
+	// -1 means never
+	left := time.Duration(-1)
+	switch {
+	case slope <= 0:
+	case disk.Used >= disk.Total:
+		left = 0
+	default:
+		seconds := float64(disk.Total-disk.Used) / slope
+		// Or time.Duration overflows, it's hundreds of years anyway
+		if seconds < float64(1<<62)/float64(time.Second) {
+			left = time.Duration(seconds * float64(time.Second))
+		}
+	}
+	reached := left >= 0 && left < within
+	return &RuleResult{Reached: reached, Cleared: !reached, PushPair: predictPushPair(r.Matcher, disk, slope, left)}, nil
+}
+
+// eg: "/ full in: 5h12m0s (used 80.0g of 100.0g, 1.2g/h)"
+// left < 0 means never.
+func predictPushPair(matcher string, disk *diskStatus, slope float64, left time.Duration) *PushPair {
+	speed := &Value{ThresholdType: ThresholdTypeSize, Value: slope * time.Hour.Seconds()}
+	detail := fmt.Sprintf("used %s of %s, %s/h", disk.Used, disk.Total, speed)
+	if left < 0 {
+		return NewPushPair(matcher+" full in", "never ("+detail+")")
+	}
+	return NewPushPair(matcher+" full in", fmt.Sprintf("%s (%s)", left.Round(time.Minute), detail))
+}
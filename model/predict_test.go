@@ -0,0 +1,67 @@
+package model_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lollipopkit/server_box_monitor/model"
+)
+
+func TestRuleCheckPredict(t *testing.T) {
+	s := new(model.ServerStatus)
+	if err := s.Refresh(_testHostFS(t)); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Disk) == 0 {
+		t.Fatal("expect disks")
+	}
+	matcher := s.Disk[0].MountPath
+	h := model.NewHistory()
+	rule := model.Rule{
+		MonitorType: model.MonitorTypeDisk,
+		Matcher:     matcher,
+		Mode:        model.RuleModePredict,
+		Window:      "1h",
+		Within:      "6h",
+	}
+
+	const gb = 1024 * 1024 * 1024
+	var result *model.RuleResult
+	var err error
+	// Grows 1g every 30m, 3g left at last
+	for i := 0; i <= 2; i++ {
+		sample := s.Clone()
+		sample.Time = s.Time.Add(time.Duration(i) * 30 * time.Minute)
+		sample.Disk[0].Total = 10 * gb
+		sample.Disk[0].Used = model.Size(5+i) * gb
+		result, err = rule.Check(sample, h)
+		if i < 2 && !errors.Is(err, model.ErrNotReady) {
+			t.Fatalf("sample %d: expect ErrNotReady, got %v", i, err)
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Reached {
+		t.Errorf("%s: expect reached", rule.Id())
+	}
+	msg := model.PushFormat("{{msg}}").Format("", []*model.PushPair{result.PushPair}, false)
+	expect := matcher + " full in: 1h30m0s (used 7.0g of 10.0g, 2.0g/h)"
+	if msg != expect {
+		t.Errorf("expect %q, got %q", expect, msg)
+	}
+
+	// Not growing
+	sample := s.Clone()
+	sample.Time = s.Time.Add(2 * time.Hour)
+	sample.Disk[0].Total = 10 * gb
+	sample.Disk[0].Used = 5 * gb
+	result, err = rule.Check(sample, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Reached || !result.Cleared {
+		t.Errorf("%s: expect cleared, got %+v", rule.Id(), result)
+	}
+}
@@ -1,13 +1,17 @@
 package model
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"net/http"
 	"net/url"
 	"regexp"
 	"strings"
+	"time"
 
-	"github.com/lollipopkit/gommon/http"
 	"github.com/lollipopkit/server_box_monitor/res"
 )
 
@@ -20,10 +24,14 @@ const (
 	PushTypeBark                = "bark"
 )
 
+var pushTypes = []PushType{PushTypeIOS, PushTypeWebhook, PushTypeServerChan, PushTypeBark}
+
 type Push struct {
 	Type  PushType        `json:"type"`
 	Name  string          `json:"name"`
 	Iface json.RawMessage `json:"iface"`
+	// Also push when a firing rule is resolved, these pushes are not rate limited
+	Resolved bool `json:"resolved,omitempty"`
 }
 
 func (p *Push) GetIface() (PushIface, error) {
@@ -60,12 +68,47 @@ func (p *Push) GetIface() (PushIface, error) {
 	return nil, fmt.Errorf("unknown push type: %s", p.Type)
 }
 
-func (p *Push) Push(args []*PushPair) error {
+// Push sends args to p, name is the server name in `{{name}}`.
+// The request is cancelled once ctx is done.
+func (p *Push) Push(ctx context.Context, name string, args []*PushPair) error {
 	iface, err := p.GetIface()
 	if err != nil {
 		return err
 	}
-	return iface.push(args)
+	return iface.push(ctx, name, args)
+}
+
+// doRequest is http.Do of gommon with ctx.
+func doRequest(ctx context.Context, method, url string, content any, headers map[string]string) ([]byte, int, error) {
+	var body io.Reader
+	switch content := content.(type) {
+	case string:
+		body = strings.NewReader(content)
+	case []byte:
+		body = bytes.NewReader(content)
+	case nil:
+	default:
+		jsonBytes, err := json.Marshal(content)
+		if err != nil {
+			return nil, 0, err
+		}
+		body = bytes.NewReader(jsonBytes)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, 0, err
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	return data, resp.StatusCode, err
 }
 
 type PushFormat string
@@ -81,15 +124,29 @@ func NewPushPair(key, value string) *PushPair {
 	}
 }
 
-func (pf PushFormat) Format(args []*PushPair, raw bool) string {
+// String returns p as it's pushed, eg: "cpu: 92.00%"
+func (p *PushPair) String() string {
+	return fmt.Sprintf("%s: %s", p.key, p.value)
+}
+
+// Resolved returns a copy of p which says the alert of p
+// is resolved after lasting d.
+// eg: "cpu: 12.00% (resolved, lasted 3m0s)"
+func (p *PushPair) Resolved(d time.Duration) *PushPair {
+	return &PushPair{
+		key:   p.key,
+		value: fmt.Sprintf("%s (resolved, lasted %s)", p.value, d.Round(time.Second)),
+	}
+}
+
+func (pf PushFormat) Format(name string, args []*PushPair, raw bool) string {
 	newline := `\n`
 	if !raw {
 		newline = "\n"
 	}
 	ss := []string{}
 	for _, arg := range args {
-		kv := fmt.Sprintf("%s: %s", arg.key, arg.value)
-		ss = append(ss, kv)
+		ss = append(ss, arg.String())
 	}
 	msgReplaced := strings.Replace(
 		string(pf),
@@ -100,14 +157,16 @@ func (pf PushFormat) Format(args []*PushPair, raw bool) string {
 	nameReplaced := strings.Replace(
 		msgReplaced,
 		res.PushFormatNameLocator,
-		Config.Name,
+		name,
 		1,
 	)
 	return nameReplaced
 }
 
 type PushIface interface {
-	push([]*PushPair) error
+	push(ctx context.Context, name string, args []*PushPair) error
+	// Paths of the errors are relative to the iface
+	diagnose() []*ConfigError
 }
 
 type PushIfaceIOS struct {
@@ -118,15 +177,16 @@ type PushIfaceIOS struct {
 	Code      int        `json:"code"`
 }
 
-func (p PushIfaceIOS) push(args []*PushPair) error {
-	content := p.Content.Format(args, false)
-	title := p.Title.Format(args, true)
+func (p PushIfaceIOS) push(ctx context.Context, name string, args []*PushPair) error {
+	content := p.Content.Format(name, args, false)
+	title := p.Title.Format(name, args, true)
 	body := map[string]string{
 		"token":   p.Token,
 		"title":   title,
 		"content": content,
 	}
-	resp, code, err := http.Do(
+	resp, code, err := doRequest(
+		ctx,
 		"POST",
 		"https://push.lolli.tech/v1/ios",
 		body,
@@ -155,6 +215,10 @@ func (p PushIfaceIOS) push(args []*PushPair) error {
 	return nil
 }
 
+func (p PushIfaceIOS) diagnose() []*ConfigError {
+	return diagnoseBodyRegex(p.BodyRegex)
+}
+
 type PushIfaceWebhook struct {
 	Url       string            `json:"url"`
 	Headers   map[string]string `json:"headers"`
@@ -164,11 +228,11 @@ type PushIfaceWebhook struct {
 	Code      int               `json:"code"`
 }
 
-func (p PushIfaceWebhook) push(args []*PushPair) error {
-	body := PushFormat(p.Body).Format(args, true)
+func (p PushIfaceWebhook) push(ctx context.Context, name string, args []*PushPair) error {
+	body := PushFormat(p.Body).Format(name, args, true)
 	switch p.Method {
 	case "GET", "POST":
-		resp, code, err := http.Do(p.Method, p.Url, body, p.Headers)
+		resp, code, err := doRequest(ctx, p.Method, p.Url, body, p.Headers)
 		if err != nil {
 			return err
 		}
@@ -189,6 +253,17 @@ func (p PushIfaceWebhook) push(args []*PushPair) error {
 	return fmt.Errorf("unknown method: %s", p.Method)
 }
 
+func (p PushIfaceWebhook) diagnose() []*ConfigError {
+	diags := diagnoseBodyRegex(p.BodyRegex)
+	if p.Url == "" {
+		diags = append(diags, newConfigError("url", "url is empty"))
+	}
+	if p.Method != "GET" && p.Method != "POST" {
+		diags = append(diags, newConfigError("method", "unknown method: %s", p.Method))
+	}
+	return diags
+}
+
 type PushIfaceServerChan struct {
 	SCKey     string     `json:"sckey"`
 	Title     PushFormat `json:"title"`
@@ -197,16 +272,16 @@ type PushIfaceServerChan struct {
 	Code      int        `json:"code"`
 }
 
-func (p PushIfaceServerChan) push(args []*PushPair) error {
-	desp := p.Desp.Format(args, true)
-	title := p.Title.Format(args, true)
+func (p PushIfaceServerChan) push(ctx context.Context, name string, args []*PushPair) error {
+	desp := p.Desp.Format(name, args, true)
+	title := p.Title.Format(name, args, true)
 	url := fmt.Sprintf(
 		"https://sctapi.ftqq.com/%s.send?title=%s&desp=%s",
 		p.SCKey,
 		title,
 		desp,
 	)
-	resp, code, err := http.Do("GET", url, nil, nil)
+	resp, code, err := doRequest(ctx, "GET", url, nil, nil)
 	if err != nil {
 		return err
 	}
@@ -225,6 +300,10 @@ func (p PushIfaceServerChan) push(args []*PushPair) error {
 	return nil
 }
 
+func (p PushIfaceServerChan) diagnose() []*ConfigError {
+	return diagnoseBodyRegex(p.BodyRegex)
+}
+
 type barkLevel string
 
 const (
@@ -243,9 +322,9 @@ type PushIfaceBark struct {
 	Code      int        `json:"code"`
 }
 
-func (p PushIfaceBark) push(args []*PushPair) error {
-	body := p.Body.Format(args, false)
-	title := p.Title.Format(args, true)
+func (p PushIfaceBark) push(ctx context.Context, name string, args []*PushPair) error {
+	body := p.Body.Format(name, args, false)
+	title := p.Title.Format(name, args, true)
 	if len(p.Server) == 0 {
 		p.Server = "https://api.day.app"
 	}
@@ -258,7 +337,7 @@ func (p PushIfaceBark) push(args []*PushPair) error {
 		"%s/%s/%s/%s",
 		p.Server, p.Key, titleEscape, bodyEscape,
 	)
-	resp, code, err := http.Do("GET", url_, nil, nil)
+	resp, code, err := doRequest(ctx, "GET", url_, nil, nil)
 	if err != nil {
 		return err
 	}
@@ -276,3 +355,7 @@ func (p PushIfaceBark) push(args []*PushPair) error {
 	}
 	return nil
 }
+
+func (p PushIfaceBark) diagnose() []*ConfigError {
+	return diagnoseBodyRegex(p.BodyRegex)
+}
@@ -0,0 +1,153 @@
+package model
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// Sample is a value at a time.
+type Sample[T any] struct {
+	Time  time.Time
+	Value T
+}
+
+// Ring keeps the latest samples up to its capacity,
+// the oldest one is overwritten when it's full.
+// Samples should be pushed in the order of time.
+type Ring[T any] struct {
+	samples []Sample[T]
+	// Index of the oldest sample when it's full
+	start    int
+	capacity int
+}
+
+func NewRing[T any](capacity int) *Ring[T] {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &Ring[T]{capacity: capacity}
+}
+
+func (r *Ring[T]) Push(t time.Time, v T) {
+	if len(r.samples) < r.capacity {
+		r.samples = append(r.samples, Sample[T]{Time: t, Value: v})
+		return
+	}
+	r.samples[r.start] = Sample[T]{Time: t, Value: v}
+	r.start = (r.start + 1) % r.capacity
+}
+
+func (r *Ring[T]) Len() int {
+	if r == nil {
+		return 0
+	}
+	return len(r.samples)
+}
+
+// At returns the i-th sample from the oldest one.
+func (r *Ring[T]) At(i int) Sample[T] {
+	return r.samples[(r.start+i)%len(r.samples)]
+}
+
+// Last returns the n-th sample from the newest one, Last(0) is the newest.
+func (r *Ring[T]) Last(n int) (Sample[T], bool) {
+	if n < 0 || n >= r.Len() {
+		return Sample[T]{}, false
+	}
+	return r.At(len(r.samples) - 1 - n), true
+}
+
+// SetLast replaces the value of the newest sample.
+func (r *Ring[T]) SetLast(v T) {
+	if len(r.samples) == 0 {
+		return
+	}
+	r.samples[(r.start+len(r.samples)-1)%len(r.samples)].Value = v
+}
+
+// Since returns the samples after t, from the oldest one.
+// If from is true, the newest sample at or before t is also included,
+// which is where a change in the window starts.
+func (r *Ring[T]) Since(t time.Time, from bool) []Sample[T] {
+	n := len(r.samples)
+	// The first sample after t
+	i := sort.Search(n, func(i int) bool {
+		return r.At(i).Time.After(t)
+	})
+	if from && i > 0 {
+		i--
+	}
+	samples := make([]Sample[T], 0, n-i)
+	for ; i < n; i++ {
+		samples = append(samples, r.At(i))
+	}
+	return samples
+}
+
+// Clone returns a copy of r which shares nothing with r.
+func (r *Ring[T]) Clone() *Ring[T] {
+	if r == nil {
+		return nil
+	}
+	return &Ring[T]{
+		samples:  cloneSlice(r.samples),
+		start:    r.start,
+		capacity: r.capacity,
+	}
+}
+
+// Samples are float samples sorted by time.
+type Samples []Sample[float64]
+
+func (ss Samples) Min() float64 {
+	min := math.Inf(1)
+	for _, s := range ss {
+		if s.Value < min {
+			min = s.Value
+		}
+	}
+	return min
+}
+
+func (ss Samples) Max() float64 {
+	max := math.Inf(-1)
+	for _, s := range ss {
+		if s.Value > max {
+			max = s.Value
+		}
+	}
+	return max
+}
+
+func (ss Samples) Avg() float64 {
+	if len(ss) == 0 {
+		return math.NaN()
+	}
+	var sum float64
+	for _, s := range ss {
+		sum += s.Value
+	}
+	return sum / float64(len(ss))
+}
+
+// Percentile returns the p-th (0 ~ 100) percentile
+// by the nearest rank method.
+func (ss Samples) Percentile(p float64) float64 {
+	if len(ss) == 0 {
+		return math.NaN()
+	}
+	values := make([]float64, len(ss))
+	for i, s := range ss {
+		values[i] = s.Value
+	}
+	sort.Float64s(values)
+	rank := int(math.Ceil(p / 100 * float64(len(values))))
+	if rank < 1 {
+		rank = 1
+	}
+	if rank > len(values) {
+		rank = len(values)
+	}
+	return values[rank-1]
+}
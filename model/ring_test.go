@@ -0,0 +1,67 @@
+package model_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/lollipopkit/server_box_monitor/model"
+)
+
+func TestRing(t *testing.T) {
+	r := model.NewRing[float64](4)
+	start := time.Unix(0, 0)
+	for i := 1; i <= 6; i++ {
+		r.Push(start.Add(time.Duration(i)*time.Minute), float64(i))
+	}
+	if r.Len() != 4 {
+		t.Fatalf("expect 4 samples, got %d", r.Len())
+	}
+	if first := r.At(0); first.Value != 3 {
+		t.Errorf("expect the oldest to be 3, got %v", first.Value)
+	}
+	if last, _ := r.Last(0); last.Value != 6 {
+		t.Errorf("expect the newest to be 6, got %v", last.Value)
+	}
+	if _, ok := r.Last(4); ok {
+		t.Error("expect no 5th sample")
+	}
+
+	now := start.Add(6 * time.Minute)
+	samples := model.Samples(r.Since(now.Add(-2*time.Minute), false))
+	if len(samples) != 2 || samples[0].Value != 5 {
+		t.Errorf("expect [5 6], got %v", samples)
+	}
+	samples = model.Samples(r.Since(now.Add(-2*time.Minute), true))
+	if len(samples) != 3 || samples[0].Value != 4 {
+		t.Errorf("expect [4 5 6], got %v", samples)
+	}
+
+	clone := r.Clone()
+	r.Push(now.Add(time.Minute), 7)
+	if last, _ := clone.Last(0); last.Value != 6 {
+		t.Errorf("clone should not be changed, got %v", last.Value)
+	}
+}
+
+func TestSamplesAggregate(t *testing.T) {
+	samples := model.Samples{}
+	for i := 1; i <= 100; i++ {
+		samples = append(samples, model.Sample[float64]{Value: float64(101 - i)})
+	}
+	if v := samples.Min(); v != 1 {
+		t.Errorf("min: expect 1, got %v", v)
+	}
+	if v := samples.Max(); v != 100 {
+		t.Errorf("max: expect 100, got %v", v)
+	}
+	if v := samples.Avg(); v != 50.5 {
+		t.Errorf("avg: expect 50.5, got %v", v)
+	}
+	if v := samples.Percentile(95); v != 95 {
+		t.Errorf("p95: expect 95, got %v", v)
+	}
+	if v := (model.Samples{}).Avg(); !math.IsNaN(v) {
+		t.Errorf("avg of nothing: expect NaN, got %v", v)
+	}
+}
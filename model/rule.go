@@ -3,8 +3,10 @@ package model
 import (
 	"errors"
 	"fmt"
-	"strconv"
 	"strings"
+	"time"
+
+	"github.com/lollipopkit/server_box_monitor/res"
 )
 
 var (
@@ -26,328 +28,276 @@ type Rule struct {
 	// MonitorType = "net" && Matcher = "eth0" -> out + in speed of eth0
 	// MonitorType = "disk" && Matcher = "/dev/sda1" -> used percent of sda1
 	// MonitorType = "disk" && Matcher = "/" -> used percent of mounted path "/"
-	// MonitorType = "temp" && Matcher = "x86_pkg" -> temperature of x86_pkg
+	// MonitorType = "temp" && Matcher = "x86_pkg_temp" -> temperature of the zone whose type is x86_pkg_temp
+	// MonitorType = "temp" && Matcher = "thermal_zone0" -> temperature of thermal_zone0
 	Matcher string `json:"matcher"`
+	// How long the threshold should keep being reached before notifying,
+	// such as "2m". Empty means notifying at the first tick.
+	For string `json:"for,omitempty"`
+	// Threshold to resolve the firing alert, such as "<70%" for ">=80%".
+	// Empty means resolving once Threshold is not reached.
+	// It should be in the same unit as Threshold.
+	// It's an Expr if Expr is used.
+	Clear string `json:"clear,omitempty"`
+	// "" compares the value with Threshold,
+	// RuleModeChange compares the change of the value in Window,
+	// RuleModePredict predicts when the disk is full by the samples in Window.
+	Mode RuleMode `json:"mode,omitempty"`
+	// Used by RuleModeChange and RuleModePredict, such as "10m"
+	Window string `json:"window,omitempty"`
+	// Only used by RuleModePredict, such as "6h":
+	// notify if the disk will be full within 6 hours.
+	Within string `json:"within,omitempty"`
+	// eg: "cpu > 90% && mem.avail < 500m"
+	// If it's not empty, MonitorType, Threshold and Matcher are not used.
+	// See Expr for the syntax.
+	Expr string `json:"expr,omitempty"`
 }
 
 func (r *Rule) Id() string {
-	return fmt.Sprintf("Rule(%s %s %s)", r.MonitorType, r.Threshold, r.Matcher)
+	id := fmt.Sprintf("%s %s %s", r.MonitorType, r.Threshold, r.Matcher)
+	if r.Expr != "" {
+		id = r.Expr
+	}
+	if r.For != "" {
+		id += " for " + r.For
+	}
+	switch r.Mode {
+	case RuleModeChange:
+		id += " change in " + r.Window
+	case RuleModePredict:
+		id = fmt.Sprintf("%s %s full within %s by %s", r.MonitorType, r.Matcher, r.Within, r.Window)
+	}
+	if r.Clear != "" {
+		id += " clear " + r.Clear
+	}
+	return "Rule(" + id + ")"
 }
-func (r *Rule) ShouldNotify(s *serverStatus) (bool, *PushPair, error) {
-	t, err := ParseToThreshold(r.Threshold)
+
+// GetFor returns the parsed r.For, 0 if it's empty.
+func (r *Rule) GetFor() (time.Duration, error) {
+	if r.For == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(r.For)
 	if err != nil {
-		return false, nil, errors.Join(ErrInvalidRule, err)
+		return 0, errors.Join(ErrInvalidRule, err)
 	}
-	switch r.MonitorType {
-	case MonitorTypeCPU:
-		return r.shouldNotifyCPU(s.CPU, t)
-	case MonitorTypeMemory:
-		return r.shouldNotifyMemory(s.Mem, t)
-	case MonitorTypeSwap:
-		return r.shouldNotifySwap(s.Swap, t)
-	case MonitorTypeDisk:
-		return r.shouldNotifyDisk(s.Disk, t)
-	case MonitorTypeNetwork:
-		return r.shouldNotifyNetwork(s.Network, t)
-	case MonitorTypeTemperature:
-		return r.shouldNotifyTemperature(s.Temperature, t)
-	default:
-		return false, nil, errors.Join(ErrInvalidRule, fmt.Errorf("invalid monitor type: %s", r.MonitorType))
+	if d < 0 {
+		return 0, errors.Join(ErrInvalidRule, fmt.Errorf("negative for: %s", r.For))
 	}
+	return d, nil
 }
 
-func (r *Rule) shouldNotifyCPU(ss []oneCpuStatus, t *Threshold) (bool, *PushPair, error) {
-	if len(ss) == 0 {
-		// utils.Warn("cpu is not valid, skip this rule")
-		return false, nil, nil
-	}
-	// 默认获取所有cpu
-	// cpu -> idx = 0 （默认）
-	// cpu0 -> idx = 1
-	// idx = CPU序号 + 1
-	var idx int64 = 0
-	if r.Matcher != "" && r.Matcher != "cpu" {
-		idx_, err := strconv.ParseUint(strings.Replace(r.Matcher, "cpu", "", 1), 10, 64)
-		if err != nil {
-			return false, nil, errors.Join(ErrInvalidRule, err)
-		}
-		idx = int64(idx_ + 1)
+// GetWindow returns the parsed r.Window.
+func (r *Rule) GetWindow() (time.Duration, error) {
+	d, err := time.ParseDuration(r.Window)
+	if err != nil {
+		return 0, errors.Join(ErrInvalidRule, err)
+	}
+	if d <= 0 || d > res.MaxWindow {
+		return 0, errors.Join(ErrInvalidRule, fmt.Errorf("window %s is not in (0, %s]", r.Window, res.MaxWindow))
 	}
+	return d, nil
+}
 
-	if idx < 0 || int(idx) >= len(ss) {
-		return false, nil, errors.Join(ErrInvalidRule, fmt.Errorf("cpu index out of range: %d", idx))
+// GetWithin returns the parsed r.Within.
+func (r *Rule) GetWithin() (time.Duration, error) {
+	d, err := time.ParseDuration(r.Within)
+	if err != nil {
+		return 0, errors.Join(ErrInvalidRule, err)
 	}
-	s := ss[idx]
-	switch t.ThresholdType {
-	case ThresholdTypePercent:
-		percent, err := s.UsedPercent()
-		if err != nil {
-			return false, nil, err
-		}
-		ok, err := t.True(percent)
-		if err != nil {
-			return false, nil, err
-		}
-		usedPercent, err := s.UsedPercent()
-		if err != nil {
-			return false, nil, err
-		}
-		key := "cpu"
-		if idx > 0 {
-			key = fmt.Sprintf("cpu%d", idx-1)
-		}
-		return ok, NewPushPair(
-			key,
-			fmt.Sprintf("%.2f%%", usedPercent),
-		), nil
-	default:
-		return false, nil, errors.Join(ErrInvalidRule, fmt.Errorf("invalid threshold type for cpu: %s", t.ThresholdType.Name()))
+	if d <= 0 {
+		return 0, errors.Join(ErrInvalidRule, fmt.Errorf("within should be positive: %s", r.Within))
 	}
+	return d, nil
 }
-func (r *Rule) shouldNotifyMemory(s *memStatus, t *Threshold) (bool, *PushPair, error) {
-	if s == nil {
-		// utils.Warn("memory is not valid, skip this rule")
-		return false, nil, nil
-	}
-	var size Size
-	var percent float64
-	switch r.Matcher {
-	case "avail":
-		size = s.Avail
-		percent = float64(s.Avail) / float64(s.Total)
-	case "free":
-		size = s.Free
-		percent = float64(s.Free) / float64(s.Total)
-	case "used":
-		size = s.Used
-		percent = float64(s.Used) / float64(s.Total)
-	default:
-		return false, nil, errors.Join(ErrInvalidRule, fmt.Errorf("invalid matcher: %s", r.Matcher))
-	}
 
-	// 使用百分比来对比
-	percent *= 100
+// RuleResult is the result of a rule in one tick.
+type RuleResult struct {
+	// Threshold is reached
+	Reached bool
+	// Clear is reached, see Rule.ShouldClear
+	Cleared bool
+	*PushPair
+}
 
-	switch t.ThresholdType {
-	case ThresholdTypeSize:
-		ok, err := t.True(size)
+// Check checks r against s, h is used to look back in RuleModeChange.
+func (r *Rule) Check(s *ServerStatus, h *History) (*RuleResult, error) {
+	switch r.Mode {
+	case RuleModeChange:
+		return r.checkChange(s, h)
+	case RuleModePredict:
+		return r.checkPredict(s, h)
+	}
+	if r.Expr != "" {
+		reached, pushPair, err := r.evalExpr(s, r.Expr)
 		if err != nil {
-			return false, nil, err
+			return nil, err
 		}
-		return ok, NewPushPair(
-			"Mem "+r.Matcher,
-			size.String(),
-		), nil
-	case ThresholdTypePercent:
-		ok, err := t.True(percent)
-		if err != nil {
-			return false, nil, err
+		cleared := !reached
+		if r.Clear != "" {
+			cleared, _, err = r.evalExpr(s, r.Clear)
+			if err != nil {
+				return nil, err
+			}
 		}
-		return ok, NewPushPair(
-			"Mem "+r.Matcher,
-			fmt.Sprintf("%.2f%%", percent),
-		), nil
-	default:
-		return false, nil, errors.Join(ErrInvalidRule, fmt.Errorf("invalid threshold type for memory: %s", t.ThresholdType.Name()))
-	}
-}
-func (r *Rule) shouldNotifySwap(s *swapStatus, t *Threshold) (bool, *PushPair, error) {
-	if s == nil {
-		// utils.Warn("swap is not valid, skip this rule")
-		return false, nil, nil
-	}
-	var size Size
-	var percent float64
-	switch r.Matcher {
-	case "used":
-		size = s.Used
-		percent = float64(s.Used) / float64(s.Total)
-	case "free":
-		size = s.Free
-		percent = float64(s.Free) / float64(s.Total)
-	default:
-		return false, nil, errors.Join(ErrInvalidRule, fmt.Errorf("invalid matcher: %s", r.Matcher))
+		return &RuleResult{Reached: reached, Cleared: cleared, PushPair: pushPair}, nil
 	}
 
-	// 使用百分比来对比
-	percent *= 100
-
-	switch t.ThresholdType {
-	case ThresholdTypeSize:
-		ok, err := t.True(size)
-		if err != nil {
-			return false, nil, err
-		}
-		return ok, NewPushPair(
-			"Swap "+r.Matcher,
-			size.String(),
-		), nil
-	case ThresholdTypePercent:
-		ok, err := t.True(percent)
+	reached, v, err := r.compare(s, h, r.Threshold)
+	if err != nil {
+		return nil, err
+	}
+	cleared := !reached
+	if r.Clear != "" {
+		cleared, _, err = r.compare(s, h, r.Clear)
 		if err != nil {
-			return false, nil, err
+			return nil, err
 		}
-		return ok, NewPushPair(
-			"Swap "+r.Matcher,
-			fmt.Sprintf("%.2f%%", percent),
-		), nil
-	default:
-		return false, nil, errors.Join(ErrInvalidRule, fmt.Errorf("invalid threshold type for swap: %s", t.ThresholdType.Name()))
 	}
+	return &RuleResult{Reached: reached, Cleared: cleared, PushPair: v.PushPair()}, nil
 }
-func (r *Rule) shouldNotifyDisk(s []diskStatus, t *Threshold) (bool, *PushPair, error) {
-	if len(s) == 0 {
-		// utils.Warn("disk is not valid, skip this rule")
-		return false, nil, nil
-	}
-	var disk diskStatus
-	var have bool
-	for _, d := range s {
-		if d.MountPath == r.Matcher || d.Filesystem == r.Matcher {
-			disk = d
-			have = true
-			break
-		}
+
+// compare returns whether the value which r points to reaches threshold.
+// The value is recorded in h, which can be nil if threshold has no aggregate.
+func (r *Rule) compare(s *ServerStatus, h *History, threshold string) (bool, *Value, error) {
+	t, err := ParseToThreshold(threshold)
+	if err != nil {
+		return false, nil, errors.Join(ErrInvalidRule, err)
 	}
-	if !have {
-		return false, nil, errors.Join(ErrInvalidRule, fmt.Errorf("disk not found: %s", r.Matcher))
+	v, err := r.value(s, t)
+	if err != nil {
+		return false, nil, err
 	}
-
-	switch t.ThresholdType {
-	case ThresholdTypeSize:
-		ok, err := t.True(disk.Used)
-		if err != nil {
-			return false, nil, err
+	if h != nil {
+		h.Record(MetricKey(r.MonitorType, r.Matcher, t.ThresholdType), v.Value, s.Time)
+	}
+	if t.Aggregate.Func != AggregateNone {
+		if h == nil {
+			return false, nil, errors.Join(ErrInvalidRule, fmt.Errorf("%s needs history", t.Aggregate))
 		}
-		return ok, NewPushPair(
-			r.Matcher,
-			disk.Used.String(),
-		), nil
-	case ThresholdTypePercent:
-		ok, err := t.True(disk.UsedPercent)
+		aggregated, err := h.Aggregate(MetricKey(r.MonitorType, r.Matcher, t.ThresholdType), s.Time, t.Aggregate)
 		if err != nil {
 			return false, nil, err
 		}
-		return ok, NewPushPair(
-			r.Matcher,
-			fmt.Sprintf("%.2f%%", disk.UsedPercent),
-		), nil
-	default:
-		return false, nil, errors.Join(ErrInvalidRule, fmt.Errorf("invalid threshold type for disk: %s", t.ThresholdType.Name()))
+		// eg: "cpu avg(5m0s)"
+		v = &Value{Key: v.Key + " " + t.Aggregate.String(), ThresholdType: v.ThresholdType, Value: aggregated}
 	}
-}
-func (r *Rule) shouldNotifyNetwork(s []networkStatus, t *Threshold) (bool, *PushPair, error) {
-	if len(s) == 0 {
-		return false, nil, nil
+	ok, err := t.True(v.Value)
+	if err != nil {
+		return false, nil, err
 	}
+	return ok, v, nil
+}
 
-	var net networkIface
-	// 如果 matcher 为空，则默认计算所有网卡
-	if len(s) == 0 {
-		net = AllNetworkStatus(Status.Network)
-	} else {
-		var have bool
-		for _, n := range s {
-			if strings.Contains(r.Matcher, n.Interface) {
-				net = n
-				have = true
-				break
-			}
-		}
-		if !have {
-			return false, nil, errors.Join(ErrInvalidRule, fmt.Errorf("network interface not found: %s", r.Matcher))
-		}
+// eg: "disk / grows by more than 1g in 10m" is
+// {"type": "disk", "matcher": "/", "threshold": ">1g", "mode": "change", "window": "10m"}
+func (r *Rule) checkChange(s *ServerStatus, h *History) (*RuleResult, error) {
+	t, err := ParseToThreshold(r.Threshold)
+	if err != nil {
+		return nil, errors.Join(ErrInvalidRule, err)
 	}
-
-	// 判断是否计算出/入流量
-	in := strings.Contains(r.Matcher, "-in")
-	out := strings.Contains(r.Matcher, "-out")
-	if !in && !out {
-		// 如果没有指定方向，则默认计算 出+入 流量
-		in = true
-		out = true
+	window, err := r.GetWindow()
+	if err != nil {
+		return nil, err
+	}
+	v, err := r.value(s, t)
+	if err != nil {
+		return nil, err
+	}
+	key := MetricKey(r.MonitorType, r.Matcher, t.ThresholdType)
+	h.Record(key, v.Value, s.Time)
+	change, err := h.Change(key, s.Time, window)
+	if err != nil {
+		return nil, err
+	}
+	changeValue := &Value{
+		Key:           fmt.Sprintf("%s change in %s", v.Key, r.Window),
+		ThresholdType: v.ThresholdType,
+		Value:         change,
 	}
 
-	switch t.ThresholdType {
-	case ThresholdTypeSpeed:
-		speed := Size(0)
-		if in {
-			s, err := net.ReceiveSpeed()
-			if err != nil {
-				return false, nil, err
-			}
-			speed += s
-		}
-		if out {
-			s, err := net.TransmitSpeed()
-			if err != nil {
-				return false, nil, err
-			}
-			speed += s
-		}
-		ok, err := t.True(speed)
+	reached, err := t.True(change)
+	if err != nil {
+		return nil, err
+	}
+	cleared := !reached
+	if r.Clear != "" {
+		clear, err := ParseToThreshold(r.Clear)
 		if err != nil {
-			return false, nil, err
-		}
-		return ok, NewPushPair(
-			r.Matcher,
-			speed.String()+"/s",
-		), nil
-	case ThresholdTypeSize:
-		size := Size(0)
-		if in {
-			size += net.Receive()
-		}
-		if out {
-			size += net.Transmit()
+			return nil, errors.Join(ErrInvalidRule, err)
 		}
-		ok, err := t.True(size)
+		cleared, err = clear.True(change)
 		if err != nil {
-			return false, nil, err
+			return nil, err
 		}
-		return ok, NewPushPair(
-			r.Matcher,
-			size.String(),
-		), nil
-	default:
-		return false, nil, errors.Join(ErrInvalidRule, fmt.Errorf("invalid threshold type for network: %s", t.ThresholdType.Name()))
 	}
+	return &RuleResult{Reached: reached, Cleared: cleared, PushPair: changeValue.PushPair()}, nil
 }
 
-func (r *Rule) shouldNotifyTemperature(s []temperatureStatus, t *Threshold) (bool, *PushPair, error) {
-	if len(s) == 0 {
-		// utils.Warn("temperature is not valid, skip this rule")
-		return false, nil, nil
+func (r *Rule) ShouldNotify(s *ServerStatus) (bool, *PushPair, error) {
+	if r.Expr != "" {
+		return r.evalExpr(s, r.Expr)
+	}
+	ok, v, err := r.compare(s, nil, r.Threshold)
+	if err != nil {
+		return false, nil, err
 	}
+	return ok, v.PushPair(), nil
+}
 
-	var temp temperatureStatus
-	var have bool
-	for _, t := range s {
-		if strings.Contains(t.Name, r.Matcher) {
-			temp = t
-			have = true
-			break
-		}
+// ShouldClear returns whether the firing alert of r can be resolved.
+// If r.Clear is empty, it's the opposite of ShouldNotify.
+func (r *Rule) ShouldClear(s *ServerStatus) (bool, error) {
+	if r.Clear == "" {
+		notify, _, err := r.ShouldNotify(s)
+		return !notify, err
 	}
-	if !have {
-		return false, nil, errors.Join(ErrInvalidRule, fmt.Errorf("temperature not found: %s", r.Matcher))
+	if r.Expr != "" {
+		cleared, _, err := r.evalExpr(s, r.Clear)
+		return cleared, err
 	}
+	ok, _, err := r.compare(s, nil, r.Clear)
+	return ok, err
+}
 
-	switch t.ThresholdType {
-	case ThresholdTypeSize:
-		ok, err := t.True(temp.Value)
-		if err != nil {
-			return false, nil, err
-		}
-		return ok, NewPushPair(
-			r.Matcher,
-			fmt.Sprintf("%.2f°C", temp.Value),
-		), nil
-	default:
-		return false, nil, errors.Join(ErrInvalidRule, fmt.Errorf("invalid threshold type for temperature: %s", t.ThresholdType.Name()))
+// evalExpr returns the result of expr,
+// values of all comparisons in it are joined in one PushPair.
+// eg: "cpu > 90% && mem.avail < 500m: cpu: 92.00%, Mem avail: 300.0m"
+func (r *Rule) evalExpr(s *ServerStatus, expr string) (bool, *PushPair, error) {
+	e, err := ParseExpr(expr)
+	if err != nil {
+		return false, nil, errors.Join(ErrInvalidRule, err)
+	}
+	ok, values, err := e.Eval(s)
+	if err != nil {
+		return false, nil, err
+	}
+	ss := make([]string, 0, len(values))
+	for _, v := range values {
+		ss = append(ss, v.Key+": "+v.String())
+	}
+	return ok, NewPushPair(r.Expr, strings.Join(ss, ", ")), nil
+}
+
+func (r *Rule) value(s *ServerStatus, t *Threshold) (*Value, error) {
+	c := CollectorOf(r.MonitorType)
+	if c == nil {
+		return nil, errors.Join(ErrInvalidRule, fmt.Errorf("invalid monitor type: %s", r.MonitorType))
 	}
+	return c.Value(s, r.MonitorType, r.Matcher, t.ThresholdType)
 }
 
+type RuleMode string
+
+const (
+	RuleModeValue  RuleMode = ""
+	RuleModeChange RuleMode = "change"
+	// Only for MonitorTypeDisk
+	RuleModePredict RuleMode = "predict"
+)
+
 type MonitorType string
 
 const (
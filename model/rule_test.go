@@ -0,0 +1,109 @@
+package model_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lollipopkit/server_box_monitor/model"
+)
+
+func TestRuleShouldNotify(t *testing.T) {
+	s := new(model.ServerStatus)
+	if err := s.Refresh(_testHostFS(t)); err != nil {
+		t.Fatal(err)
+	}
+	_shouldNotify(t, s, model.Rule{MonitorType: model.MonitorTypeMemory, Threshold: ">=50%", Matcher: "used"}, false)
+	_shouldNotify(t, s, model.Rule{MonitorType: model.MonitorTypeMemory, Threshold: "<1g", Matcher: "free"}, true)
+	_shouldNotify(t, s, model.Rule{MonitorType: model.MonitorTypeSwap, Threshold: ">=10%", Matcher: "used"}, true)
+	_shouldNotify(t, s, model.Rule{MonitorType: model.MonitorTypeTemperature, Threshold: ">40c", Matcher: "x86_pkg_temp"}, true)
+	_shouldNotify(t, s, model.Rule{MonitorType: model.MonitorTypeDisk, Threshold: ">=0%", Matcher: "/boot"}, true)
+	_shouldNotify(t, s, model.Rule{MonitorType: model.MonitorTypeNetwork, Threshold: ">1g", Matcher: "eth0-in"}, true)
+}
+
+func TestRuleShouldNotifyInvalid(t *testing.T) {
+	s := new(model.ServerStatus)
+	if err := s.Refresh(_testHostFS(t)); err != nil {
+		t.Fatal(err)
+	}
+	rules := []model.Rule{
+		{MonitorType: "gpu", Threshold: ">1%", Matcher: "gpu0"},
+		{MonitorType: model.MonitorTypeTemperature, Threshold: ">1m", Matcher: "x86_pkg_temp"},
+		{MonitorType: model.MonitorTypeMemory, Threshold: ">1m", Matcher: "cached"},
+	}
+	for _, rule := range rules {
+		_, _, err := rule.ShouldNotify(s)
+		if !errors.Is(err, model.ErrInvalidRule) {
+			t.Errorf("%s: expect ErrInvalidRule, got %v", rule.Id(), err)
+		}
+	}
+}
+
+func _shouldNotify(t *testing.T, s *model.ServerStatus, rule model.Rule, expect bool) {
+	notify, pair, err := rule.ShouldNotify(s)
+	if err != nil {
+		t.Errorf("%s: %v", rule.Id(), err)
+		return
+	}
+	if notify != expect {
+		t.Errorf("%s: expect %v, got %v", rule.Id(), expect, notify)
+	}
+	if pair == nil {
+		t.Errorf("%s: expect push pair", rule.Id())
+	}
+}
+
+func TestRuleShouldClear(t *testing.T) {
+	s := new(model.ServerStatus)
+	if err := s.Refresh(_testHostFS(t)); err != nil {
+		t.Fatal(err)
+	}
+	rule := model.Rule{MonitorType: model.MonitorTypeTemperature, Threshold: ">=50c", Matcher: "x86_pkg_temp", Clear: "<40c"}
+	cleared, err := rule.ShouldClear(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 45°C is between the thresholds
+	if cleared {
+		t.Errorf("%s: should not be cleared", rule.Id())
+	}
+	rule.Clear = ""
+	cleared, err = rule.ShouldClear(s)
+	if err != nil || !cleared {
+		t.Errorf("%s: expect cleared without clear threshold, got %v %v", rule.Id(), cleared, err)
+	}
+}
+
+func TestRuleCheckAggregate(t *testing.T) {
+	s := new(model.ServerStatus)
+	if err := s.Refresh(_testHostFS(t)); err != nil {
+		t.Fatal(err)
+	}
+	h := model.NewHistory()
+	rule := model.Rule{MonitorType: model.MonitorTypeTemperature, Threshold: "avg2m>40c", Matcher: "x86_pkg_temp"}
+	if _, err := rule.Check(s, h); !errors.Is(err, model.ErrNotReady) {
+		t.Fatalf("expect ErrNotReady before the window is covered, got %v", err)
+	}
+
+	// 45°C then 30°C, 30°C
+	var result *model.RuleResult
+	for i := 1; i <= 2; i++ {
+		sample := s.Clone()
+		sample.Time = s.Time.Add(time.Duration(i) * time.Minute)
+		for j := range sample.Temperature {
+			sample.Temperature[j].Value = 30
+		}
+		var err error
+		result, err = rule.Check(sample, h)
+		if i == 2 && err != nil {
+			t.Fatal(err)
+		}
+	}
+	if result.Reached {
+		t.Errorf("%s: avg of the last 2m is 30°C, should not be reached", rule.Id())
+	}
+
+	if _, _, err := rule.ShouldNotify(s); !errors.Is(err, model.ErrInvalidRule) {
+		t.Errorf("expect ErrInvalidRule without history, got %v", err)
+	}
+}
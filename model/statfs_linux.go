@@ -0,0 +1,12 @@
+package model
+
+import "syscall"
+
+func statfs(path string) (total, free, avail Size, err error) {
+	var st syscall.Statfs_t
+	if err = syscall.Statfs(path, &st); err != nil {
+		return
+	}
+	bsize := Size(st.Bsize)
+	return Size(st.Blocks) * bsize, Size(st.Bfree) * bsize, Size(st.Bavail) * bsize, nil
+}
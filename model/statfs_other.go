@@ -0,0 +1,9 @@
+//go:build !linux
+
+package model
+
+import "errors"
+
+func statfs(path string) (total, free, avail Size, err error) {
+	return 0, 0, 0, errors.New("statfs is only supported on linux")
+}
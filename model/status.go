@@ -3,39 +3,74 @@ package model
 import (
 	"errors"
 	"fmt"
-	"os"
-	"path/filepath"
-	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/lollipopkit/gommon/log"
-	"github.com/lollipopkit/gommon/sys"
-	"github.com/lollipopkit/server_box_monitor/res"
 )
 
 var (
-	ErrNotReady           = errors.New("not ready")
-	ErrRunShellFailed     = errors.New("run shell failed")
-	ErrInvalidShellOutput = errors.New("invalid shell output")
+	ErrNotReady      = errors.New("not ready")
+	ErrInvalidStatus = errors.New("invalid status")
 )
 
-var (
-	Status = new(serverStatus)
-)
-
-type serverStatus struct {
+// ServerStatus is the status of the server collected in one tick.
+// A published status is shared by readers, it should not be modified.
+// Use Clone to get a copy for the next tick.
+type ServerStatus struct {
 	CPU         []oneCpuStatus
 	Mem         *memStatus
 	Swap        *swapStatus
 	Disk        []diskStatus
 	Network     []networkStatus
 	Temperature []temperatureStatus
+	// When the last Refresh finished
+	Time time.Time
+}
+
+// Clone returns a copy of ss which shares nothing mutable with ss.
+func (ss *ServerStatus) Clone() *ServerStatus {
+	if ss == nil {
+		return new(ServerStatus)
+	}
+	clone := &ServerStatus{
+		CPU:         cloneSlice(ss.CPU),
+		Disk:        cloneSlice(ss.Disk),
+		Network:     cloneSlice(ss.Network),
+		Temperature: cloneSlice(ss.Temperature),
+		Time:        ss.Time,
+	}
+	for i := range clone.CPU {
+		clone.CPU[i].Samples = clone.CPU[i].Samples.Clone()
+	}
+	for i := range clone.Network {
+		clone.Network[i].Samples = clone.Network[i].Samples.Clone()
+	}
+	if ss.Mem != nil {
+		mem := *ss.Mem
+		clone.Mem = &mem
+	}
+	if ss.Swap != nil {
+		swap := *ss.Swap
+		clone.Swap = &swap
+	}
+	return clone
+}
+
+func cloneSlice[T any](s []T) []T {
+	if s == nil {
+		return nil
+	}
+	return append(make([]T, 0, len(s)), s...)
 }
 
 type temperatureStatus struct {
 	Value float64
-	Name  string
+	// Type of the zone, several zones can have the same one, eg: "acpitz"
+	Name string
+	// Unique name of the zone, eg: "thermal_zone0"
+	Zone string
 }
 
 type cpuOneTimeStatus struct {
@@ -43,17 +78,23 @@ type cpuOneTimeStatus struct {
 	Total int
 }
 
+// Counters of CPU and network only need the last two samples to get rates,
+// values in longer windows are kept in History.
+const counterSamples = 2
+
 type oneCpuStatus struct {
-	Core int
-	TimeSequence[cpuOneTimeStatus]
+	Core    int
+	Samples *Ring[cpuOneTimeStatus]
 }
 
 func (cs *oneCpuStatus) UsedPercent() (float64, error) {
-	if cs.TimeSequence.New == nil || cs.TimeSequence.Old == nil {
+	newSample, ok := cs.Samples.Last(0)
+	oldSample, ok2 := cs.Samples.Last(1)
+	if !ok || !ok2 {
 		return 0, ErrNotReady
 	}
-	used := cs.TimeSequence.New.Used - cs.TimeSequence.Old.Used
-	total := cs.TimeSequence.New.Total - cs.TimeSequence.Old.Total
+	used := newSample.Value.Used - oldSample.Value.Used
+	total := newSample.Value.Total - oldSample.Value.Total
 	if total == 0 {
 		return 0, ErrNotReady
 	}
@@ -97,28 +138,46 @@ type networkIface interface {
 
 type networkStatus struct {
 	Interface string
-	TimeSequence[networkOneTimeStatus]
+	Samples   *Ring[networkOneTimeStatus]
 }
 
 func (ns networkStatus) TransmitSpeed() (Size, error) {
-	if ns.TimeSequence.New == nil || ns.TimeSequence.Old == nil {
-		return 0, ErrNotReady
+	newSample, oldSample, seconds, err := ns.lastTwo()
+	if err != nil {
+		return 0, err
 	}
-	diff := float64(ns.TimeSequence.New.Transmit - ns.TimeSequence.Old.Transmit)
-	return Size(diff / CheckInterval.Seconds()), nil
+	diff := float64(newSample.Transmit - oldSample.Transmit)
+	return Size(diff / seconds), nil
 }
 func (ns networkStatus) ReceiveSpeed() (Size, error) {
-	if ns.TimeSequence.New == nil || ns.TimeSequence.Old == nil {
-		return 0, ErrNotReady
+	newSample, oldSample, seconds, err := ns.lastTwo()
+	if err != nil {
+		return 0, err
+	}
+	diff := float64(newSample.Receive - oldSample.Receive)
+	return Size(diff / seconds), nil
+}
+
+// lastTwo returns the last two samples and the seconds between them
+func (ns networkStatus) lastTwo() (networkOneTimeStatus, networkOneTimeStatus, float64, error) {
+	newSample, ok := ns.Samples.Last(0)
+	oldSample, ok2 := ns.Samples.Last(1)
+	if !ok || !ok2 {
+		return networkOneTimeStatus{}, networkOneTimeStatus{}, 0, ErrNotReady
+	}
+	seconds := newSample.Time.Sub(oldSample.Time).Seconds()
+	if seconds <= 0 {
+		return networkOneTimeStatus{}, networkOneTimeStatus{}, 0, ErrNotReady
 	}
-	diff := float64(ns.TimeSequence.New.Receive - ns.TimeSequence.Old.Receive)
-	return Size(diff / CheckInterval.Seconds()), nil
+	return newSample.Value, oldSample.Value, seconds, nil
 }
 func (ns networkStatus) Transmit() Size {
-	return ns.TimeSequence.New.Transmit
+	sample, _ := ns.Samples.Last(0)
+	return sample.Value.Transmit
 }
 func (ns networkStatus) Receive() Size {
-	return ns.TimeSequence.New.Receive
+	sample, _ := ns.Samples.Last(0)
+	return sample.Value.Receive
 }
 
 type AllNetworkStatus []networkStatus
@@ -160,104 +219,84 @@ func (nss AllNetworkStatus) Receive() Size {
 	return Size(sum)
 }
 
-func RefreshStatus() error {
-	output, _ := sys.Execute("sh", res.ServerBoxShellPath)
-	err := os.WriteFile(filepath.Join(res.ServerBoxDirPath, "shell_output.log"), []byte(output), 0644)
-	if err != nil {
-		log.Warn("[STATUS] write shell output log failed: %s", err)
-	}
-	return ParseStatus(output)
-}
-
-func ParseStatus(s string) error {
-	segments := strings.Split(s, "SrvBox")
-	for i := range segments {
-		segments[i] = strings.TrimSpace(segments[i])
-		segments[i] = strings.Trim(segments[i], "\n")
-	}
-	if len(segments) != 7 {
-		return errors.Join(ErrInvalidShellOutput, fmt.Errorf("expect 7 segments, but got %d", len(segments)))
-	}
-	err := ParseNetworkStatus(segments[1])
-	if err != nil {
-		log.Warn("parse network status failed: %s", err)
-	}
-	err = ParseCPUStatus(segments[2])
-	if err != nil {
-		log.Warn("parse cpu status failed: %s", err)
-	}
-	err = ParseDiskStatus(segments[3])
-	if err != nil {
-		log.Warn("parse disk status failed: %s", err)
-	}
-	err = ParseMemAndSwapStatus(segments[4])
-	if err != nil {
-		log.Warn("parse mem status failed: %s", err)
-	}
-	err = ParseTemperatureStatus(segments[5], segments[6])
-	if err != nil {
-		log.Warn("parse temperature status failed: %s", err)
+// Refresh runs every registered collector against fs.
+// A failed collector only leaves its own part of ss untouched.
+func (ss *ServerStatus) Refresh(fs *HostFS) error {
+	for _, c := range collectors {
+		err := c.Collect(fs, ss)
+		if err != nil {
+			log.Warn("[STATUS] collect %s failed: %s", c.Name(), err)
+		}
 	}
+	ss.Time = time.Now()
 	return nil
 }
 
-func initMem() {
-	if Status.Mem == nil {
-		Status.Mem = &memStatus{}
-	}
-}
-func initSwap() {
-	if Status.Swap == nil {
-		Status.Swap = &swapStatus{}
-	}
-}
-
-func ParseMemAndSwapStatus(s string) error {
-	initMem()
-	initSwap()
-	lines := strings.Split(s, "\n")
+// parseMeminfo returns the sizes in /proc/meminfo by their names.
+// eg: "MemTotal" -> 8048072 * 1024
+func parseMeminfo(s string) (map[string]Size, error) {
+	sizes := map[string]Size{}
+	lines := strings.Split(strings.TrimSpace(s), "\n")
 	for i := range lines {
-		line := strings.TrimSpace(lines[i])
+		fields := strings.Fields(lines[i])
+		if len(fields) < 2 {
+			continue
+		}
 
-		value, err := strconv.ParseInt(strings.Fields(line)[1], 10, 64)
+		value, err := strconv.ParseInt(fields[1], 10, 64)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		// KB -> B
 		// because the unit of MemTotal/... is KB
-		size := Size(value) * Size(programKilo)
+		sizes[strings.TrimSuffix(fields[0], ":")] = Size(value) * Size(programKilo)
+	}
+	return sizes, nil
+}
 
-		switch true {
-		case strings.HasPrefix(line, "MemTotal:"):
-			Status.Mem.Total = size
-		case strings.HasPrefix(line, "MemFree:"):
-			Status.Mem.Free = size
-		case strings.HasPrefix(line, "MemAvailable:"):
-			Status.Mem.Avail = size
-		case strings.HasPrefix(line, "SwapTotal:"):
-			Status.Swap.Total = size
-		case strings.HasPrefix(line, "SwapFree:"):
-			Status.Swap.Free = size
-		case strings.HasPrefix(line, "SwapCached:"):
-			Status.Swap.Used = size
-		}
+func (ss *ServerStatus) ParseMemStatus(s string) error {
+	sizes, err := parseMeminfo(s)
+	if err != nil {
+		return err
+	}
+	mem := &memStatus{
+		Total: sizes["MemTotal"],
+		Free:  sizes["MemFree"],
+		Avail: sizes["MemAvailable"],
+	}
+	mem.Used = mem.Total - mem.Avail
+	ss.Mem = mem
+	return nil
+}
+
+func (ss *ServerStatus) ParseSwapStatus(s string) error {
+	sizes, err := parseMeminfo(s)
+	if err != nil {
+		return err
+	}
+	swap := &swapStatus{
+		Total:  sizes["SwapTotal"],
+		Free:   sizes["SwapFree"],
+		Cached: sizes["SwapCached"],
 	}
-	Status.Mem.Used = Status.Mem.Total - Status.Mem.Avail
+	swap.Used = swap.Total - swap.Free
+	ss.Swap = swap
 	return nil
 }
 
-func ParseCPUStatus(s string) error {
+func (ss *ServerStatus) ParseCPUStatus(s string) error {
+	now := time.Now()
 	lines := strings.Split(strings.TrimSpace(s), "\n")
 	count := len(lines)
-	if len(Status.CPU) != count {
-		Status.CPU = make([]oneCpuStatus, count)
+	if len(ss.CPU) != count {
+		ss.CPU = make([]oneCpuStatus, count)
 	}
 	for i := range lines {
 		line := strings.TrimSpace(lines[i])
 		if strings.HasPrefix(line, "cpu") {
 			fields := strings.Fields(line)
 			if len(fields) != 11 {
-				return errors.Join(ErrInvalidShellOutput, fmt.Errorf("invalid cpu status: %s", line))
+				return errors.Join(ErrInvalidStatus, fmt.Errorf("invalid cpu status: %s", line))
 			}
 			idle, err := strconv.Atoi(fields[4])
 			if err != nil {
@@ -271,7 +310,10 @@ func ParseCPUStatus(s string) error {
 				}
 				total += v
 			}
-			Status.CPU[i].TimeSequence.Update(&cpuOneTimeStatus{
+			if ss.CPU[i].Samples == nil {
+				ss.CPU[i].Samples = NewRing[cpuOneTimeStatus](counterSamples)
+			}
+			ss.CPU[i].Samples.Push(now, cpuOneTimeStatus{
 				Used:  total - idle,
 				Total: total,
 			})
@@ -280,91 +322,33 @@ func ParseCPUStatus(s string) error {
 	return nil
 }
 
-func ParseDiskStatus(s string) error {
+func (ss *ServerStatus) ParseNetworkStatus(s string) error {
+	now := time.Now()
 	lines := strings.Split(strings.TrimSpace(s), "\n")
-	lines = lines[1:]
 	count := len(lines)
-	if len(Status.Disk) != count {
-		Status.Disk = make([]diskStatus, count)
-	}
-	failIdx := sort.IntSlice{}
-	for i := range lines {
-		line := strings.TrimSpace(lines[i])
-		fields := strings.Fields(line)
-		if len(fields) != 6 {
-			failIdx = append(failIdx, i)
-			continue
-		}
-		Status.Disk[i].MountPath = fields[5]
-		Status.Disk[i].Filesystem = fields[0]
-		total, err := ParseToSize(fields[1])
-		if err != nil {
-			failIdx = append(failIdx, i)
-			continue
-		}
-		Status.Disk[i].Total = total
-		used, err := ParseToSize(fields[2])
-		if err != nil {
-			failIdx = append(failIdx, i)
-			continue
-		}
-		Status.Disk[i].Used = used
-		avail, err := ParseToSize(fields[3])
-		if err != nil {
-			failIdx = append(failIdx, i)
-			continue
-		}
-		Status.Disk[i].Avail = avail
-		Status.Disk[i].UsedPercent = (float64(used) / float64(total)) * 100
-	}
-	sort.Sort(sort.Reverse(failIdx))
-	for _, idx := range failIdx {
-		Status.Disk = append(Status.Disk[:idx], Status.Disk[idx+1:]...)
-	}
-	return nil
-}
-
-func ParseTemperatureStatus(s1, s2 string) error {
-	if strings.Contains(s1, "/sys/class/thermal/thermal_zone*/type") {
-		return nil
-	}
-	types := strings.Split(strings.TrimSpace(s1), "\n")
-	values := strings.Split(strings.TrimSpace(s2), "\n")
-	if len(types) != len(values) {
-		return errors.Join(ErrInvalidShellOutput, fmt.Errorf("invalid temperature status: %s, %s", s1, s2))
+	// Two lines of headers
+	if count < 2 {
+		return errors.Join(ErrInvalidStatus, fmt.Errorf("invalid network status: %q", s))
 	}
-	count := len(types)
-	if len(Status.Temperature) != count {
-		Status.Temperature = make([]temperatureStatus, count)
-	}
-	for i := range types {
-		Status.Temperature[i].Name = strings.TrimSpace(types[i])
-		value, err := strconv.ParseFloat(strings.TrimSpace(values[i]), 64)
-		if err != nil {
-			return err
-		}
-		Status.Temperature[i].Value = value / 1000
-	}
-	return nil
-}
-
-func ParseNetworkStatus(s string) error {
-	lines := strings.Split(strings.TrimSpace(s), "\n")
-	count := len(lines)
-	if len(Status.Network) != count-2 {
-		Status.Network = make([]networkStatus, count-2)
+	if len(ss.Network) != count-2 {
+		ss.Network = make([]networkStatus, count-2)
 	}
 	for i := range lines {
 		if i < 2 {
 			continue
 		}
 		line := strings.TrimSpace(lines[i])
-		fields := strings.Fields(line)
+		// Big counters stick to the name, eg: "eth0:1209472863"
+		name, counters, found := strings.Cut(line, ":")
+		if !found {
+			return errors.Join(ErrInvalidStatus, fmt.Errorf("invalid network status: %s", line))
+		}
+		fields := append([]string{name}, strings.Fields(counters)...)
 		if len(fields) != 17 {
-			return errors.Join(ErrInvalidShellOutput, fmt.Errorf("invalid network status: %s", line))
+			return errors.Join(ErrInvalidStatus, fmt.Errorf("invalid network status: %s", line))
 		}
 		idx := i - 2
-		Status.Network[idx].Interface = strings.TrimRight(fields[0], ":")
+		ss.Network[idx].Interface = strings.TrimSpace(name)
 		receiveBytes, err := strconv.ParseUint(fields[1], 10, 64)
 		if err != nil {
 			return err
@@ -375,7 +359,10 @@ func ParseNetworkStatus(s string) error {
 			return err
 		}
 		transmit := Size(transmitBytes)
-		Status.Network[idx].TimeSequence.Update(&networkOneTimeStatus{
+		if ss.Network[idx].Samples == nil {
+			ss.Network[idx].Samples = NewRing[networkOneTimeStatus](counterSamples)
+		}
+		ss.Network[idx].Samples.Push(now, networkOneTimeStatus{
 			Receive:  receive,
 			Transmit: transmit,
 		})
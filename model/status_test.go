@@ -1,21 +1,36 @@
 package model_test
 
 import (
-	_ "embed"
+	"errors"
 	"testing"
 
 	"github.com/lollipopkit/server_box_monitor/model"
 )
 
-var (
-	//go:embed test/disk
-	_disk string
-)
+func TestStatusClone(t *testing.T) {
+	s := new(model.ServerStatus)
+	if err := _testHostFS(t).ReadDiskStatus(s); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ParseMemStatus("MemTotal: 1024 kB\nMemAvailable: 512 kB"); err != nil {
+		t.Fatal(err)
+	}
+	clone := s.Clone()
+	clone.Disk[0].MountPath = "/changed"
+	clone.Mem.Used = 0
+	if s.Disk[0].MountPath == "/changed" {
+		t.Error("disk of clone should not share memory with the origin")
+	}
+	if s.Mem.Used == 0 {
+		t.Error("mem of clone should not share memory with the origin")
+	}
+}
 
-func TestParseDisk(t *testing.T) {
-	err := model.ParseDiskStatus(_disk)
-	if err != nil {
-		t.Error(err)
+func TestParseNetworkStatusInvalid(t *testing.T) {
+	for _, input := range []string{"", "Inter-|   Receive"} {
+		s := new(model.ServerStatus)
+		if err := s.ParseNetworkStatus(input); !errors.Is(err, model.ErrInvalidStatus) {
+			t.Errorf("%q: expect ErrInvalidStatus, got %v", input, err)
+		}
 	}
-	t.Log(model.Status.Disk)
 }
@@ -3,141 +3,347 @@ package model
 import (
 	"errors"
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
+	"time"
+	"unicode"
 
-	"github.com/lollipopkit/gommon/util"
+	"github.com/lollipopkit/server_box_monitor/res"
 )
 
+var (
+	ErrEmptyThreshold   = errors.New("empty threshold")
+	ErrMissingOperator  = errors.New("missing operator")
+	ErrMissingNumber    = errors.New("missing number")
+	ErrInvalidNumber    = errors.New("invalid number")
+	ErrMissingUnit      = errors.New("missing unit")
+	ErrUnknownUnit      = errors.New("unknown unit")
+	ErrUnexpectedSymbol = errors.New("unexpected symbol")
+)
+
+// ThresholdError reports where a threshold or an Expr is invalid.
+// errors.Is(err, ErrMissingUnit) can be used to check the reason.
+type ThresholdError struct {
+	Input string
+	// Index of the rune where the error is
+	Pos int
+	Err error
+}
+
+func (e *ThresholdError) Error() string {
+	return fmt.Sprintf("%s at %d of %q", e.Err, e.Pos, e.Input)
+}
+func (e *ThresholdError) Unwrap() error {
+	return e.Err
+}
+
 type Threshold struct {
 	ThresholdType
 	Value float64
 	CompareType
+	// Compare the aggregated value in a window if it's not AggregateNone
+	Aggregate Aggregate
 }
 
+// ParseToThreshold parses s in the form of `[aggregate] operator number unit`.
+// Spaces between them are ignored, units are case insensitive.
+//
+//	aggregate: "avg" "min" "max" + window, "p" + percentile + (window)
+//	operator: "<" "<=" "=" "!=" ">=" ">"
+//	unit: "%" -> percent, "b" "k" "m" "g" "t" -> size,
+//	      size unit + "/s" -> speed, "c" -> temperature
+//
+// eg: ">=80.5%" "< 100m" "!=10m/s" ">85c" "avg5m>=80%" "p95(10m)>50m/s"
 func ParseToThreshold(s string) (*Threshold, error) {
-	s = strings.ToLower(s)
-	runes := []rune(s)
-	runesLen := len(runes)
+	p := newThresholdParser(s)
+	p.skipSpace()
+	if p.eof() {
+		return nil, p.error(ErrEmptyThreshold)
+	}
+	aggregate, err := p.parseAggregate()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	compareType, err := p.parseCompareType()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	t, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if !p.eof() {
+		return nil, p.error(ErrUnexpectedSymbol)
+	}
+	t.CompareType = compareType
+	t.Aggregate = aggregate
+	return t, nil
+}
 
-	var thresholdType ThresholdType
-	var compareType CompareType
-	var startIdx, endIdx int
+// thresholdParser is a hand written scanner of thresholds.
+type thresholdParser struct {
+	input string
+	runes []rune
+	pos   int
+}
 
-	// 判断阈值类型
+func newThresholdParser(s string) *thresholdParser {
+	return &thresholdParser{input: s, runes: []rune(s)}
+}
 
-	if strings.Contains(s, "%") { // 10%
-		thresholdType = ThresholdTypePercent
-		endIdx = runesLen - 1
+func (p *thresholdParser) eof() bool {
+	return p.pos >= len(p.runes)
+}
+
+// peek returns the current rune in lower case, or 0 at the end.
+func (p *thresholdParser) peek() rune {
+	if p.eof() {
+		return 0
+	}
+	return unicode.ToLower(p.runes[p.pos])
+}
 
-	} else if strings.HasSuffix(s, "/s") { // 10m/s
-		thresholdType = ThresholdTypeSpeed
-		// 10m/s -> "/s" -> 2
-		endIdx = runesLen - 2
+func (p *thresholdParser) skipSpace() {
+	for !p.eof() && unicode.IsSpace(p.runes[p.pos]) {
+		p.pos++
+	}
+}
 
-	} else if util.Contains(sizeSuffix, string(runes[runesLen-1])) { // 10m
-		// 10m -> "" -> 0
-		thresholdType = ThresholdTypeSize
-		endIdx = runesLen
+func (p *thresholdParser) error(err error) *ThresholdError {
+	return &ThresholdError{Input: p.input, Pos: p.pos, Err: err}
+}
 
-	} else if strings.HasSuffix(s, "c") { // 32c
-		thresholdType = ThresholdTypeTemperature
-		// 32c -> c -> 1
-		endIdx = runesLen - 1
+func (p *thresholdParser) parseCompareType() (CompareType, error) {
+	first := p.peek()
+	var second rune
+	if p.pos+1 < len(p.runes) {
+		second = p.runes[p.pos+1]
 	}
+	switch {
+	case first == '<' && second == '=':
+		p.pos += 2
+		return CompareTypeLessOrEqual, nil
+	case first == '<':
+		p.pos++
+		return CompareTypeLess, nil
+	case first == '>' && second == '=':
+		p.pos += 2
+		return CompareTypeGreaterOrEqual, nil
+	case first == '>':
+		p.pos++
+		return CompareTypeGreater, nil
+	case first == '!' && second == '=':
+		p.pos += 2
+		return CompareTypeNotEqual, nil
+	case first == '=' && second == '=':
+		p.pos += 2
+		return CompareTypeEqual, nil
+	case first == '=':
+		p.pos++
+		return CompareTypeEqual, nil
+	}
+	return 0, p.error(ErrMissingOperator)
+}
 
-	runes = runes[:len(runes)-1]
-	if runes[0] == '<' {
-		if runes[1] == '=' {
-			compareType = CompareTypeLessOrEqual
-			startIdx = 2
-		} else {
-			compareType = CompareTypeLess
-			startIdx = 1
+// parseAggregate parses the optional aggregate before the operator,
+// eg: "avg5m" "max(1m)" "p95(10m)"
+func (p *thresholdParser) parseAggregate() (Aggregate, error) {
+	start := p.pos
+	for !p.eof() && unicode.IsLetter(p.runes[p.pos]) {
+		p.pos++
+	}
+	if p.pos == start {
+		return Aggregate{}, nil
+	}
+	a := Aggregate{Func: AggregateFunc(strings.ToLower(string(p.runes[start:p.pos])))}
+	switch a.Func {
+	case AggregateAvg, AggregateMin, AggregateMax:
+	case AggregatePercentile:
+		numStart := p.pos
+		for !p.eof() && (unicode.IsDigit(p.runes[p.pos]) || p.runes[p.pos] == '.') {
+			p.pos++
 		}
-	} else if runes[0] == '>' {
-		if runes[1] == '=' {
-			compareType = CompareTypeGreaterOrEqual
-			startIdx = 2
-		} else {
-			compareType = CompareTypeGreater
-			startIdx = 1
+		percentile, err := strconv.ParseFloat(string(p.runes[numStart:p.pos]), 64)
+		if err != nil || percentile < 0 || percentile > 100 {
+			p.pos = numStart
+			return Aggregate{}, p.error(ErrInvalidNumber)
+		}
+		a.Percentile = percentile
+		// "p955m" is ambiguous
+		if p.peek() != '(' {
+			return Aggregate{}, p.error(ErrInvalidWindow)
 		}
-	} else if runes[0] == '=' {
-		compareType = CompareTypeEqual
-		startIdx = 0
+	default:
+		p.pos = start
+		return Aggregate{}, p.error(ErrUnknownAggregate)
 	}
 
-	var value float64
-	var err error
+	parens := p.peek() == '('
+	if parens {
+		p.pos++
+	}
+	windowStart := p.pos
+	for !p.eof() && !unicode.IsSpace(p.runes[p.pos]) && !strings.ContainsRune("<>=!()", p.runes[p.pos]) {
+		p.pos++
+	}
+	window, err := time.ParseDuration(string(p.runes[windowStart:p.pos]))
+	if err != nil || window <= 0 || window > res.MaxWindow {
+		p.pos = windowStart
+		return Aggregate{}, p.error(ErrInvalidWindow)
+	}
+	a.Window = window
+	if parens {
+		if p.peek() != ')' {
+			return Aggregate{}, p.error(ErrUnclosedParenthesis)
+		}
+		p.pos++
+	}
+	return a, nil
+}
 
-	switch thresholdType {
-	case ThresholdTypeSize, ThresholdTypeSpeed:
-		size, err := ParseToSize(string(runes[startIdx:endIdx]))
-		if err != nil {
-			return nil, err
+// parseValue parses `number unit`, eg: "80%" "10 m/s"
+func (p *thresholdParser) parseValue() (*Threshold, error) {
+	start := p.pos
+	if p.peek() == '-' {
+		p.pos++
+	}
+	digits := 0
+	dot := false
+	for !p.eof() {
+		r := p.peek()
+		if r >= '0' && r <= '9' {
+			digits++
+		} else if r == '.' && !dot {
+			dot = true
+		} else {
+			break
 		}
-		value = float64(size)
+		p.pos++
+	}
+	if digits == 0 {
+		p.pos = start
+		return nil, p.error(ErrMissingNumber)
+	}
+	value, err := strconv.ParseFloat(string(p.runes[start:p.pos]), 64)
+	if err != nil {
+		p.pos = start
+		return nil, p.error(ErrInvalidNumber)
+	}
+
+	p.skipSpace()
+	t := &Threshold{Value: value}
+	switch unit := p.peek(); {
+	case p.eof():
+		return nil, p.error(ErrMissingUnit)
+	case unit == '%':
+		p.pos++
+		t.ThresholdType = ThresholdTypePercent
+	case unit == 'c':
+		p.pos++
+		t.ThresholdType = ThresholdTypeTemperature
 	default:
-		value, err = strconv.ParseFloat(string(runes[startIdx:endIdx]), 64)
-		if err != nil {
-			return nil, err
+		nth := -1
+		for i, suffix := range sizeSuffix {
+			if string(unit) == suffix {
+				nth = i
+				break
+			}
+		}
+		if nth < 0 {
+			return nil, p.error(ErrUnknownUnit)
+		}
+		p.pos++
+		for i := 0; i < nth; i++ {
+			t.Value *= programKilo
+		}
+		t.ThresholdType = ThresholdTypeSize
+		if p.peek() == '/' {
+			p.pos++
+			// "10m/m" is not allowed
+			if p.peek() != 's' {
+				return nil, p.error(ErrUnknownUnit)
+			}
+			p.pos++
+			t.ThresholdType = ThresholdTypeSpeed
+		}
+		if t.Value < 0 || math.IsInf(t.Value, 0) {
+			p.pos = start
+			return nil, p.error(ErrInvalidNumber)
 		}
 	}
-
-	return &Threshold{
-		ThresholdType: thresholdType,
-		Value:         value,
-		CompareType:   compareType,
-	}, nil
+	return t, nil
 }
 
 func (t *Threshold) True(now any) (bool, error) {
-	switch t.ThresholdType {
-	case ThresholdTypePercent, ThresholdTypeTemperature:
-		var nowValue float64
-		switch now.(type) {
-		case float64:
-			nowValue = now.(float64)
-		case int:
-			nowValue = float64(now.(int))
-		case int64:
-			nowValue = float64(now.(int64))
-		default:
-			return false, errors.Join(ErrInvalidRule, fmt.Errorf("%v is %T", now, now))
-		}
-		switch t.CompareType {
-		case CompareTypeLess:
-			return nowValue < t.Value, nil
-		case CompareTypeLessOrEqual:
-			return nowValue <= t.Value, nil
-		case CompareTypeEqual:
-			return nowValue == t.Value, nil
-		case CompareTypeGreaterOrEqual:
-			return nowValue >= t.Value, nil
-		case CompareTypeGreater:
-			return nowValue > t.Value, nil
-		}
-	case ThresholdTypeSize, ThresholdTypeSpeed:
-		now, ok := now.(Size)
-		if !ok {
-			return false, errors.Join(ErrInvalidRule, fmt.Errorf("%v is not Size", now))
+	var nowValue float64
+	switch now := now.(type) {
+	case float64:
+		nowValue = now
+	case int:
+		nowValue = float64(now)
+	case int64:
+		nowValue = float64(now)
+	case Size:
+		nowValue = float64(now)
+	default:
+		return false, errors.Join(ErrInvalidRule, fmt.Errorf("%v is %T", now, now))
+	}
+	switch t.CompareType {
+	case CompareTypeLess:
+		return nowValue < t.Value, nil
+	case CompareTypeLessOrEqual:
+		return nowValue <= t.Value, nil
+	case CompareTypeEqual:
+		return nowValue == t.Value, nil
+	case CompareTypeGreaterOrEqual:
+		return nowValue >= t.Value, nil
+	case CompareTypeGreater:
+		return nowValue > t.Value, nil
+	case CompareTypeNotEqual:
+		return nowValue != t.Value, nil
+	}
+	return false, fmt.Errorf("not support %#v", t)
+}
+
+// checkClear returns an error if clear can't be used
+// to stop the alert of t, eg: ">=80%" can be cleared by "<70%",
+// but not "<90%" or ">70%".
+func (t *Threshold) checkClear(clear *Threshold) error {
+	if clear.ThresholdType != t.ThresholdType {
+		return fmt.Errorf("clear is %s, but threshold is %s", clear.ThresholdType.Name(), t.ThresholdType.Name())
+	}
+	switch t.CompareType {
+	case CompareTypeGreater, CompareTypeGreaterOrEqual:
+		if !clear.CompareType.isLess() || clear.Value > t.Value {
+			return fmt.Errorf("clear should be below %s, eg: <%s", t, strconv.FormatFloat(t.Value, 'f', -1, 64))
 		}
-		nowFloat64 := float64(now)
-		switch t.CompareType {
-		case CompareTypeLess:
-			return nowFloat64 < t.Value, nil
-		case CompareTypeLessOrEqual:
-			return nowFloat64 <= t.Value, nil
-		case CompareTypeEqual:
-			return nowFloat64 == t.Value, nil
-		case CompareTypeGreaterOrEqual:
-			return nowFloat64 >= t.Value, nil
-		case CompareTypeGreater:
-			return nowFloat64 > t.Value, nil
+	case CompareTypeLess, CompareTypeLessOrEqual:
+		if !clear.CompareType.isGreater() || clear.Value < t.Value {
+			return fmt.Errorf("clear should be above %s, eg: >%s", t, strconv.FormatFloat(t.Value, 'f', -1, 64))
 		}
 	}
-	return false, fmt.Errorf("not support %#v", t)
+	return nil
+}
+
+// String returns t in the form which ParseToThreshold accepts,
+// sizes are in bytes. eg: ">=80%" "<1048576b/s"
+func (t *Threshold) String() string {
+	value := strconv.FormatFloat(t.Value, 'f', -1, 64)
+	switch t.ThresholdType {
+	case ThresholdTypePercent:
+		value += "%"
+	case ThresholdTypeSize:
+		value += "b"
+	case ThresholdTypeSpeed:
+		value += "b/s"
+	case ThresholdTypeTemperature:
+		value += "c"
+	}
+	return t.Aggregate.String() + t.CompareType.String() + value
 }
 
 type CompareType uint8
@@ -148,8 +354,34 @@ const (
 	CompareTypeEqual                             // =
 	CompareTypeGreaterOrEqual                    // >=
 	CompareTypeGreater                           // >
+	CompareTypeNotEqual                          // !=
 )
 
+func (ct CompareType) isLess() bool {
+	return ct == CompareTypeLess || ct == CompareTypeLessOrEqual
+}
+func (ct CompareType) isGreater() bool {
+	return ct == CompareTypeGreater || ct == CompareTypeGreaterOrEqual
+}
+
+func (ct CompareType) String() string {
+	switch ct {
+	case CompareTypeLess:
+		return "<"
+	case CompareTypeLessOrEqual:
+		return "<="
+	case CompareTypeEqual:
+		return "="
+	case CompareTypeGreaterOrEqual:
+		return ">="
+	case CompareTypeGreater:
+		return ">"
+	case CompareTypeNotEqual:
+		return "!="
+	}
+	return "?"
+}
+
 type ThresholdType uint8
 
 const (
@@ -157,7 +389,6 @@ const (
 	// eg: 80% 80.001%
 	ThresholdTypePercent
 	// eg: 100m 10k 1g
-	// Values should be in lower case
 	ThresholdTypeSize
 	// eg: 10m/s
 	ThresholdTypeSpeed
@@ -165,6 +396,17 @@ const (
 	ThresholdTypeTemperature
 )
 
+// ThresholdTypeOf returns the type whose Name is name,
+// or ThresholdTypeUnknown.
+func ThresholdTypeOf(name string) ThresholdType {
+	for tt := ThresholdTypePercent; tt <= ThresholdTypeTemperature; tt++ {
+		if tt.Name() == name {
+			return tt
+		}
+	}
+	return ThresholdTypeUnknown
+}
+
 func (tt *ThresholdType) Name() string {
 	switch *tt {
 	case ThresholdTypePercent:
@@ -0,0 +1,123 @@
+package model_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lollipopkit/server_box_monitor/model"
+)
+
+func TestParseToThreshold(t *testing.T) {
+	cases := []struct {
+		s string
+		model.Threshold
+	}{
+		{">=80.5%", model.Threshold{ThresholdType: model.ThresholdTypePercent, Value: 80.5, CompareType: model.CompareTypeGreaterOrEqual}},
+		{" < 100M ", model.Threshold{ThresholdType: model.ThresholdTypeSize, Value: 100 * 1024 * 1024, CompareType: model.CompareTypeLess}},
+		{"=10m/s", model.Threshold{ThresholdType: model.ThresholdTypeSpeed, Value: 10 * 1024 * 1024, CompareType: model.CompareTypeEqual}},
+		{"!= 3 k/s", model.Threshold{ThresholdType: model.ThresholdTypeSpeed, Value: 3 * 1024, CompareType: model.CompareTypeNotEqual}},
+		{">85c", model.Threshold{ThresholdType: model.ThresholdTypeTemperature, Value: 85, CompareType: model.CompareTypeGreater}},
+		{"<-5C", model.Threshold{ThresholdType: model.ThresholdTypeTemperature, Value: -5, CompareType: model.CompareTypeLess}},
+		{"<=7b", model.Threshold{ThresholdType: model.ThresholdTypeSize, Value: 7, CompareType: model.CompareTypeLessOrEqual}},
+	}
+	for _, c := range cases {
+		threshold, err := model.ParseToThreshold(c.s)
+		if err != nil {
+			t.Errorf("%q: %v", c.s, err)
+			continue
+		}
+		if *threshold != c.Threshold {
+			t.Errorf("%q: expect %+v, got %+v", c.s, c.Threshold, *threshold)
+		}
+	}
+}
+
+func TestParseToThresholdError(t *testing.T) {
+	cases := []struct {
+		s   string
+		err error
+		pos int
+	}{
+		{"", model.ErrEmptyThreshold, 0},
+		{">", model.ErrMissingNumber, 1},
+		{"%", model.ErrMissingOperator, 0},
+		{"80%", model.ErrMissingOperator, 0},
+		{">=77", model.ErrMissingUnit, 4},
+		{">= %", model.ErrMissingNumber, 3},
+		{">10x", model.ErrUnknownUnit, 3},
+		{">10m/m", model.ErrUnknownUnit, 5},
+		{">-1m", model.ErrInvalidNumber, 1},
+		{">10% 1", model.ErrUnexpectedSymbol, 5},
+	}
+	for _, c := range cases {
+		_, err := model.ParseToThreshold(c.s)
+		var thresholdErr *model.ThresholdError
+		if !errors.As(err, &thresholdErr) {
+			t.Errorf("%q: expect ThresholdError, got %v", c.s, err)
+			continue
+		}
+		if !errors.Is(err, c.err) || thresholdErr.Pos != c.pos {
+			t.Errorf("%q: expect %v at %d, got %v", c.s, c.err, c.pos, err)
+		}
+	}
+}
+
+func FuzzParseToThreshold(f *testing.F) {
+	for _, s := range []string{">=80%", "<100m", "=10m/s", "!=32c", "", ">", "=", "<=-", ">1.5.5k", "avg5m>=80%", "p95(10m)>50m/s"} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		threshold, err := model.ParseToThreshold(s)
+		if err != nil {
+			var thresholdErr *model.ThresholdError
+			if !errors.As(err, &thresholdErr) {
+				t.Fatalf("%q: expect ThresholdError, got %v", s, err)
+			}
+			if thresholdErr.Pos < 0 || thresholdErr.Pos > len([]rune(s)) {
+				t.Fatalf("%q: position %d out of range", s, thresholdErr.Pos)
+			}
+			return
+		}
+		// String() should be parsed to the same threshold
+		again, err := model.ParseToThreshold(threshold.String())
+		if err != nil {
+			t.Fatalf("%q -> %q: %v", s, threshold.String(), err)
+		}
+		if *again != *threshold {
+			t.Fatalf("%q -> %q: expect %+v, got %+v", s, threshold.String(), *threshold, *again)
+		}
+	})
+}
+
+func TestParseToThresholdAggregate(t *testing.T) {
+	cases := map[string]model.Aggregate{
+		"avg5m>=80%":      {Func: model.AggregateAvg, Window: 5 * time.Minute},
+		"MAX(1m) < 1g":    {Func: model.AggregateMax, Window: time.Minute},
+		"p95(10m)>50m/s":  {Func: model.AggregatePercentile, Percentile: 95, Window: 10 * time.Minute},
+		"p99.9(30s) > 1%": {Func: model.AggregatePercentile, Percentile: 99.9, Window: 30 * time.Second},
+		"min 1m > 1%":     {},
+		"sum5m > 1%":      {},
+		"p955m > 1%":      {},
+		"p101(5m) > 1%":   {},
+		"avg(5m > 1%":     {},
+		"avg2h > 1%":      {},
+		"avg-1m > 1%":     {},
+	}
+	for input, expect := range cases {
+		threshold, err := model.ParseToThreshold(input)
+		if expect.Func == model.AggregateNone {
+			if err == nil {
+				t.Errorf("%q: expect error, got %s", input, threshold)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", input, err)
+			continue
+		}
+		if threshold.Aggregate != expect {
+			t.Errorf("%q: expect %+v, got %+v", input, expect, threshold.Aggregate)
+		}
+	}
+}
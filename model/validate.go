@@ -0,0 +1,398 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lollipopkit/gommon/util"
+	"github.com/lollipopkit/server_box_monitor/res"
+)
+
+// ConfigError is a problem of the config at Path.
+type ConfigError struct {
+	// JSON path, eg: "$.rules[0].threshold"
+	Path string
+	Err  error
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path, e.Err)
+}
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+// ConfigErrors is returned by Validate, it wraps ErrInvalidConfig.
+type ConfigErrors []*ConfigError
+
+func (e ConfigErrors) Error() string {
+	return errors.Join(e.Unwrap()...).Error()
+}
+func (e ConfigErrors) Unwrap() []error {
+	errs := make([]error, 0, len(e)+1)
+	errs = append(errs, ErrInvalidConfig)
+	for _, diag := range e {
+		errs = append(errs, diag)
+	}
+	return errs
+}
+
+func newConfigError(path string, format string, args ...any) *ConfigError {
+	return &ConfigError{Path: path, Err: fmt.Errorf(format, args...)}
+}
+
+// Validate checks everything which would fail at runtime,
+// the error is ConfigErrors if c is invalid.
+// Interval and rate can be empty, then the defaults are used.
+func (c *AppConfig) Validate() error {
+	diags := c.Diagnose()
+	if len(diags) == 0 {
+		return nil
+	}
+	return ConfigErrors(diags)
+}
+
+// Diagnose returns all problems of c, in the order of the fields.
+func (c *AppConfig) Diagnose() []*ConfigError {
+	diags := []*ConfigError{}
+	if c.Interval != "" {
+		d, err := time.ParseDuration(c.Interval)
+		if err != nil {
+			diags = append(diags, &ConfigError{Path: "$.interval", Err: err})
+		} else if d > res.MaxInterval || d < time.Second {
+			diags = append(diags, newConfigError("$.interval", "%s is not in [1s, %s]", d, res.MaxInterval))
+		}
+	}
+	if c.Rate != "" {
+		_, _, err := parseRate(c.Rate)
+		if err != nil {
+			diags = append(diags, &ConfigError{Path: "$.rate", Err: err})
+		}
+	}
+	for i := range c.Rules {
+		diags = append(diags, c.Rules[i].diagnose(fmt.Sprintf("$.rules[%d]", i))...)
+	}
+	// States of alerts and the admin API find rules by id
+	ids := map[string]int{}
+	for i := range c.Rules {
+		id := c.Rules[i].Id()
+		if first, ok := ids[id]; ok {
+			diags = append(diags, newConfigError(fmt.Sprintf("$.rules[%d]", i), "duplicate of rules[%d]", first))
+			continue
+		}
+		ids[id] = i
+	}
+	names := map[string]bool{}
+	for i := range c.Pushes {
+		path := fmt.Sprintf("$.pushes[%d]", i)
+		push := &c.Pushes[i]
+		// Rate limiter counts by name
+		if names[push.Name] {
+			diags = append(diags, newConfigError(path+".name", "duplicate name: %s", push.Name))
+		}
+		names[push.Name] = true
+		diags = append(diags, push.diagnose(path)...)
+	}
+	if c.History != nil {
+		if _, err := parseRetention(c.History.Raw); err != nil {
+			diags = append(diags, &ConfigError{Path: "$.history.raw", Err: err})
+		}
+		if _, err := parseRetention(c.History.Rollup); err != nil {
+			diags = append(diags, &ConfigError{Path: "$.history.rollup", Err: err})
+		}
+	}
+	if c.Auth != nil {
+		diags = append(diags, c.Auth.diagnose("$.auth")...)
+	}
+	return diags
+}
+
+// checkMatcher checks matcher if c is a MatcherChecker.
+func checkMatcher(c Collector, typ MonitorType, matcher string) error {
+	if checker, ok := c.(MatcherChecker); ok {
+		return checker.CheckMatcher(typ, matcher)
+	}
+	return nil
+}
+
+func (r *Rule) diagnose(path string) []*ConfigError {
+	if r.Expr != "" {
+		return r.diagnoseExpr(path)
+	}
+	if r.Mode == RuleModePredict {
+		return r.diagnosePredict(path)
+	}
+	diags := []*ConfigError{}
+	c := CollectorOf(r.MonitorType)
+	if c == nil {
+		diags = append(diags, newConfigError(path+".type", "invalid monitor type: %s", r.MonitorType))
+	} else if err := checkMatcher(c, r.MonitorType, r.Matcher); err != nil {
+		diags = append(diags, &ConfigError{Path: path + ".matcher", Err: err})
+	}
+	t, err := ParseToThreshold(r.Threshold)
+	if err != nil {
+		diags = append(diags, &ConfigError{Path: path + ".threshold", Err: err})
+	}
+	if c != nil && t != nil {
+		supported := c.ThresholdTypes(r.MonitorType)
+		if !util.Contains(supported, t.ThresholdType) {
+			names := make([]string, 0, len(supported))
+			for _, tt := range supported {
+				names = append(names, tt.Name())
+			}
+			diags = append(diags, newConfigError(
+				path+".threshold",
+				"%s threshold is not supported by %s, use %s",
+				t.ThresholdType.Name(), r.MonitorType, strings.Join(names, " / "),
+			))
+		}
+	}
+	if _, err := r.GetFor(); err != nil {
+		diags = append(diags, &ConfigError{Path: path + ".for", Err: err})
+	}
+	switch r.Mode {
+	case RuleModeValue:
+	case RuleModeChange:
+		if _, err := r.GetWindow(); err != nil {
+			diags = append(diags, &ConfigError{Path: path + ".window", Err: err})
+		}
+		if t != nil && t.Aggregate.Func != AggregateNone {
+			diags = append(diags, newConfigError(path+".threshold", "aggregate can't be used with change"))
+		}
+	default:
+		diags = append(diags, newConfigError(path+".mode", "unknown mode: %s", r.Mode))
+	}
+	if r.Clear != "" {
+		clear, err := ParseToThreshold(r.Clear)
+		if err != nil {
+			diags = append(diags, &ConfigError{Path: path + ".clear", Err: err})
+		} else if t != nil {
+			if err := t.checkClear(clear); err != nil {
+				diags = append(diags, &ConfigError{Path: path + ".clear", Err: err})
+			}
+		}
+	}
+	return diags
+}
+
+func (r *Rule) diagnosePredict(path string) []*ConfigError {
+	diags := []*ConfigError{}
+	if r.MonitorType != MonitorTypeDisk {
+		diags = append(diags, newConfigError(path+".type", "predict is not supported by %s, use disk", r.MonitorType))
+	} else if err := checkMatcher(CollectorOf(r.MonitorType), r.MonitorType, r.Matcher); err != nil {
+		diags = append(diags, &ConfigError{Path: path + ".matcher", Err: err})
+	}
+	if r.Threshold != "" || r.Clear != "" {
+		diags = append(diags, newConfigError(path+".threshold", "threshold and clear are not used by predict, use within"))
+	}
+	if _, err := r.GetWindow(); err != nil {
+		diags = append(diags, &ConfigError{Path: path + ".window", Err: err})
+	}
+	if _, err := r.GetWithin(); err != nil {
+		diags = append(diags, &ConfigError{Path: path + ".within", Err: err})
+	}
+	if _, err := r.GetFor(); err != nil {
+		diags = append(diags, &ConfigError{Path: path + ".for", Err: err})
+	}
+	return diags
+}
+
+func (r *Rule) diagnoseExpr(path string) []*ConfigError {
+	diags := []*ConfigError{}
+	if r.MonitorType != "" || r.Threshold != "" || r.Matcher != "" {
+		diags = append(diags, newConfigError(path+".expr", "type, threshold and matcher should be empty when using expr"))
+	}
+	if r.Mode != RuleModeValue {
+		diags = append(diags, newConfigError(path+".mode", "mode can't be used with expr"))
+	}
+	if _, err := ParseExpr(r.Expr); err != nil {
+		diags = append(diags, &ConfigError{Path: path + ".expr", Err: err})
+	}
+	if _, err := r.GetFor(); err != nil {
+		diags = append(diags, &ConfigError{Path: path + ".for", Err: err})
+	}
+	if r.Clear != "" {
+		if _, err := ParseExpr(r.Clear); err != nil {
+			diags = append(diags, &ConfigError{Path: path + ".clear", Err: err})
+		}
+	}
+	return diags
+}
+
+func (p *Push) diagnose(path string) []*ConfigError {
+	iface, err := p.GetIface()
+	if err != nil {
+		if !util.Contains(pushTypes, p.Type) {
+			return []*ConfigError{newConfigError(path+".type", "unknown push type: %s", p.Type)}
+		}
+		return []*ConfigError{{Path: path + ".iface", Err: err}}
+	}
+	diags := iface.diagnose()
+	for _, diag := range diags {
+		diag.Path = path + ".iface." + diag.Path
+	}
+	return diags
+}
+
+// diagnoseBodyRegex is shared by all PushIface
+func diagnoseBodyRegex(bodyRegex string) []*ConfigError {
+	if bodyRegex == "" {
+		return nil
+	}
+	_, err := regexp.Compile(bodyRegex)
+	if err != nil {
+		return []*ConfigError{{Path: "body_regex", Err: err}}
+	}
+	return nil
+}
+
+// DiagnoseConfigFile decodes the content of a config file and diagnoses it.
+// Decoding errors are reported with their position.
+func DiagnoseConfigFile(data []byte) (*AppConfig, []*ConfigError) {
+	migrated, _, err := MigrateConfig(data)
+	if err != nil {
+		return nil, []*ConfigError{decodeError(data, err)}
+	}
+	config := new(AppConfig)
+	err = json.Unmarshal(migrated, config)
+	if err != nil {
+		return nil, []*ConfigError{decodeError(migrated, err)}
+	}
+	diags := config.Diagnose()
+	// Misspelled keys are dropped by json.Unmarshal silently
+	var raw any
+	if err := json.Unmarshal(migrated, &raw); err == nil {
+		diags = append(diags, unknownFields("$", raw, reflect.TypeOf(config))...)
+	}
+	for i := range config.Pushes {
+		iface, err := config.Pushes[i].GetIface()
+		if err != nil {
+			continue
+		}
+		var raw any
+		if err := json.Unmarshal(config.Pushes[i].Iface, &raw); err == nil {
+			path := fmt.Sprintf("$.pushes[%d].iface", i)
+			diags = append(diags, unknownFields(path, raw, reflect.TypeOf(iface))...)
+		}
+	}
+	return config, diags
+}
+
+var rawMessageType = reflect.TypeOf(json.RawMessage{})
+
+// unknownFields returns the keys in v which are not fields of t.
+// v is decoded from JSON into any.
+func unknownFields(path string, v any, t reflect.Type) []*ConfigError {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	diags := []*ConfigError{}
+	switch t.Kind() {
+	case reflect.Struct:
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return nil
+		}
+		fields := jsonFields(t)
+		keys := make([]string, 0, len(obj))
+		for key := range obj {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			// Keys are matched case-insensitively by encoding/json
+			field, ok := fields[strings.ToLower(key)]
+			if !ok {
+				diags = append(diags, newConfigError(path+"."+key, "unknown field: %s", key))
+				continue
+			}
+			diags = append(diags, unknownFields(path+"."+key, obj[key], field)...)
+		}
+	case reflect.Slice:
+		if t == rawMessageType {
+			return nil
+		}
+		arr, ok := v.([]any)
+		if !ok {
+			return nil
+		}
+		for i := range arr {
+			diags = append(diags, unknownFields(fmt.Sprintf("%s[%d]", path, i), arr[i], t.Elem())...)
+		}
+	}
+	return diags
+}
+
+// jsonFields returns the types of fields of t by their lower case JSON names,
+// fields of embedded structs are flattened like encoding/json.
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		name, _, _ := strings.Cut(tag, ",")
+		if name == "-" {
+			continue
+		}
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			for k, v := range jsonFields(f.Type) {
+				fields[k] = v
+			}
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[strings.ToLower(name)] = f.Type
+	}
+	return fields
+}
+
+func decodeError(data []byte, err error) *ConfigError {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		line, col := position(data, syntaxErr.Offset)
+		return newConfigError("$", "line %d col %d: %s", line, col, syntaxErr)
+	case errors.As(err, &typeErr):
+		// eg: "rules.0.type" -> "$.rules[0].type"
+		path := "$"
+		for _, field := range strings.Split(typeErr.Field, ".") {
+			if field == "" {
+				continue
+			}
+			if _, err := strconv.Atoi(field); err == nil {
+				path += "[" + field + "]"
+			} else {
+				path += "." + field
+			}
+		}
+		return newConfigError(path, "expect %s, got %s", typeErr.Type.Kind(), typeErr.Value)
+	}
+	return &ConfigError{Path: "$", Err: err}
+}
+
+// position returns the line and column of offset, starting from 1
+func position(data []byte, offset int64) (int, int) {
+	line, col := 1, 1
+	for i := 0; i < int(offset) && i < len(data); i++ {
+		if data[i] == '\n' {
+			line++
+			col = 1
+		} else {
+			col++
+		}
+	}
+	return line, col
+}
@@ -0,0 +1,125 @@
+package model_test
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/lollipopkit/server_box_monitor/model"
+)
+
+// _rule returns a config with only rule in it.
+func _rule(rule string) string {
+	return `{"version": 2, "rules": [` + rule + `]}`
+}
+
+// _push returns a config with only push in it.
+func _push(push string) string {
+	return `{"version": 2, "pushes": [` + push + `]}`
+}
+
+func TestDiagnoseConfigFile(t *testing.T) {
+	const hook = `{"type": "webhook", "name": "b", "iface": {"url": "http://localhost", "method": "GET"}}`
+	cases := []struct {
+		name   string
+		config string
+		path   string
+	}{
+		{"interval", `{"version": 2, "interval": "20s"}`, "$.interval"},
+		{"missing unit", _rule(`{"type": "cpu", "threshold": ">=77", "matcher": "cpu"}`), "$.rules[0].threshold"},
+		{"unsupported unit", _rule(`{"type": "temp", "threshold": ">80%", "matcher": "x86_pkg_temp"}`), "$.rules[0].threshold"},
+		{"unknown type", _rule(`{"type": "gpu", "threshold": ">80%", "matcher": "gpu0"}`), "$.rules[0].type"},
+		{"for", _rule(`{"type": "cpu", "threshold": ">80%", "matcher": "cpu", "for": "2 min"}`), "$.rules[0].for"},
+		{"clear in the same direction", _rule(`{"type": "cpu", "threshold": ">=80%", "matcher": "cpu", "clear": "<90%"}`), "$.rules[0].clear"},
+		{"expr", _rule(`{"expr": "cpu > 90% && mem.avail < 500c"}`), "$.rules[0].expr"},
+		{"change without window", _rule(`{"type": "disk", "threshold": ">1g", "matcher": "/", "mode": "change"}`), "$.rules[0].window"},
+		{"unknown mode", _rule(`{"type": "disk", "threshold": ">1g", "matcher": "/", "mode": "trend"}`), "$.rules[0].mode"},
+		{"predict not disk", _rule(`{"type": "mem", "matcher": "used", "mode": "predict", "window": "1h", "within": "6h"}`), "$.rules[0].type"},
+		{"window too long", _rule(`{"type": "cpu", "threshold": "avg2h>=80%", "matcher": "cpu"}`), "$.rules[0].threshold"},
+		{"aggregate with change", _rule(`{"type": "disk", "threshold": "avg5m>1g", "matcher": "/", "mode": "change", "window": "10m"}`), "$.rules[0].threshold"},
+		{"duplicate rule", `{"version": 2, "rules": [{"type": "cpu", "threshold": ">80%"}, {"type": "cpu", "threshold": ">80%"}]}`, "$.rules[1]"},
+		{"body regex", _push(`{"type": "bark", "name": "b", "iface": {"key": "x", "body_regex": "(("}}`), "$.pushes[0].iface.body_regex"},
+		{"duplicate push", _push(hook + "," + hook), "$.pushes[1].name"},
+		{"method", _push(`{"type": "webhook", "name": "b", "iface": {"url": "http://localhost", "method": "PUT"}}`), "$.pushes[0].iface.method"},
+		{"push type", _push(`{"type": "email", "name": "c", "iface": {}}`), "$.pushes[0].type"},
+		{"history", `{"version": 2, "history": {"raw": "1d"}}`, "$.history.raw"},
+		{"short token", `{"version": 2, "auth": {"tokens": [{"token": "short"}]}}`, "$.auth.tokens[0].token"},
+		{"scope", `{"version": 2, "auth": {"tokens": [{"token": "0123456789abcdef", "scope": "root"}]}}`, "$.auth.tokens[0].scope"},
+		{"hash", `{"version": 2, "auth": {"users": [{"name": "a", "hash": "plain"}]}}`, "$.auth.users[0].hash"},
+	}
+	for _, c := range cases {
+		_, diags := model.DiagnoseConfigFile([]byte(c.config))
+		if len(diags) != 1 || diags[0].Path != c.path {
+			t.Errorf("%s: expect one error at %s, got %v", c.name, c.path, diags)
+		}
+	}
+
+	valid := []string{
+		_rule(`{"type": "cpu", "threshold": ">=80%", "matcher": "cpu", "clear": "<70%"}`),
+		_rule(`{"expr": "cpu > 90%", "clear": "cpu < 80%"}`),
+		_rule(`{"type": "disk", "matcher": "/", "mode": "predict", "window": "1h", "within": "6h"}`),
+		_push(hook),
+	}
+	for _, config := range valid {
+		if _, diags := model.DiagnoseConfigFile([]byte(config)); len(diags) != 0 {
+			t.Errorf("%s: expect valid, got %v", config, diags)
+		}
+	}
+}
+
+func TestDiagnoseConfigFileDecodeError(t *testing.T) {
+	_, diags := model.DiagnoseConfigFile([]byte("{\n\t\"rules\": [}"))
+	if len(diags) != 1 || diags[0].Path != "$" {
+		t.Fatalf("expect one error at $, got %v", diags)
+	}
+	_, diags = model.DiagnoseConfigFile([]byte(`{"version": 2, "rules": [{"type": 1}]}`))
+	if len(diags) != 1 || diags[0].Path != "$.rules[0].type" {
+		t.Fatalf("expect one error at $.rules[0].type, got %v", diags)
+	}
+}
+
+func TestDiagnoseConfigFileUnknownFields(t *testing.T) {
+	_, diags := model.DiagnoseConfigFile([]byte(`{
+		"version": 2,
+		"Name": "ok",
+		"rules": [{"type": "cpu", "treshold": ">80%", "threshold": ">80%", "matcher": "cpu"}],
+		"pushes": [{"type": "webhook", "name": "w", "iface": {"url": "http://localhost", "method": "GET", "header": {}}}],
+		"history": {"raws": "1h"}
+	}`))
+	expect := []string{
+		"$.history.raws",
+		"$.pushes[0].iface.header",
+		"$.rules[0].treshold",
+	}
+	paths := []string{}
+	for _, diag := range diags {
+		paths = append(paths, diag.Path)
+	}
+	sort.Strings(paths)
+	if strings.Join(paths, " ") != strings.Join(expect, " ") {
+		t.Errorf("expect %v, got %v", expect, diags)
+	}
+}
+
+func TestDiagnoseMatcher(t *testing.T) {
+	cases := map[string]bool{
+		`{"type": "mem", "threshold": ">1g", "matcher": "availible"}`: false,
+		`{"type": "mem", "threshold": ">1g", "matcher": "avail"}`:     true,
+		`{"type": "swap", "threshold": ">1g", "matcher": "avail"}`:    false,
+		`{"type": "cpu", "threshold": ">1%", "matcher": "cpu12"}`:     true,
+		`{"type": "cpu", "threshold": ">1%", "matcher": "core1"}`:     false,
+		`{"type": "disk", "threshold": ">1%", "matcher": ""}`:         false,
+		`{"type": "net", "threshold": ">1m/s", "matcher": "eth0-in"}`: true,
+		`{"type": "temp", "threshold": ">1c"}`:                        false,
+	}
+	for rule, valid := range cases {
+		_, diags := model.DiagnoseConfigFile([]byte(_rule(rule)))
+		if valid && len(diags) != 0 {
+			t.Errorf("%s: expect valid, got %v", rule, diags)
+		}
+		if !valid && (len(diags) != 1 || diags[0].Path != "$.rules[0].matcher" || !errors.Is(diags[0], model.ErrUnknownMatcher)) {
+			t.Errorf("%s: expect error at $.rules[0].matcher, got %v", rule, diags)
+		}
+	}
+}
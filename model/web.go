@@ -6,6 +6,9 @@ type WebConfig struct {
 	Addr string `json:"addr"`
 	Cert string `json:"cert"`
 	Key  string `json:"key"`
+	// CA of client certificates, clients without a certificate
+	// signed by it are rejected. It needs Cert and Key.
+	ClientCA string `json:"client_ca"`
 }
 
 func (wc *WebConfig) HaveTLS() bool {
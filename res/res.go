@@ -7,7 +7,6 @@ import (
 	"time"
 
 	"github.com/lollipopkit/gommon/log"
-	"github.com/lollipopkit/gommon/rate"
 	"github.com/lollipopkit/gommon/sys"
 )
 
@@ -22,14 +21,12 @@ const (
 )
 
 var (
-	ServerBoxShellFileName = "monitor.sh"
-	ServerBoxDirPath       = filepath.Join(os.Getenv("HOME"), ".config", "server_box")
-	ServerBoxShellPath     = filepath.Join(ServerBoxDirPath, ServerBoxShellFileName)
+	ServerBoxDirPath = filepath.Join(os.Getenv("HOME"), ".config", "server_box")
 
 	AppConfigFileName = "config.json"
 	AppConfigPath     = filepath.Join(ServerBoxDirPath, AppConfigFileName)
 
-	DefaultRateLimiter = rate.NewLimiter[string](time.Second*10, 1)
+	HistoryDirPath = filepath.Join(ServerBoxDirPath, "history")
 )
 
 const (
@@ -41,6 +38,43 @@ const (
 	DefaultSeverName   = "Server 1"
 	MaxInterval        = time.Second * 10
 
+	// Used when the rate in config is invalid
+	DefaultRateDuration = time.Second * 10
+	DefaultRateTimes    = 1
+
+	// Samples of one metric kept in memory,
+	// more than MaxWindow at the minimum interval
+	HistoryCapacity = 4096
+	// The longest window rules can look back
+	MaxWindow = time.Hour
+
+	// Alerts of rules which fail in so many checks in a row are dropped,
+	// eg: the disk is unmounted or the interface is removed
+	RuleStaleChecks = 5
+
+	// Points returned by the history API at most
+	HistoryMaxPoints = 1000
+
+	// Retention of the history on disk
+	DefaultHistoryRaw    = 24 * time.Hour
+	DefaultHistoryRollup = 30 * 24 * time.Hour
+
+	// How often the config file is checked for changes
+	ConfigWatchInterval = time.Second * 3
+
+	// Docker kills the container 10s after SIGTERM
+	WebShutdownTimeout = time.Second * 2
+	PushDrainTimeout   = time.Second * 7
+	PushQueueSize      = 16
+
+	// Shorter tokens are easy to guess
+	MinAuthTokenLen = 16
+
+	// Events buffered for one stream client, it's dropped when the buffer is full
+	StreamBufferSize = 16
+	// Keeps idle streams from being closed by proxies
+	StreamHeartbeat = time.Second * 15
+
 	PushFormatMsgLocator  = "{{msg}}"
 	PushFormatNameLocator = "{{name}}"
 )
@@ -0,0 +1,219 @@
+'use strict';
+
+// Points kept in the traffic charts
+const MAX_POINTS = 120;
+// Paths are relative to /ui/
+const API = '../api/v1/';
+
+// Token of the API, it's given by `?token=` of the page
+// and kept for the next visits.
+const params = new URLSearchParams(location.search);
+if (params.has('token')) {
+  localStorage.setItem('token', params.get('token'));
+  history.replaceState(null, '', location.pathname);
+}
+const TOKEN = localStorage.getItem('token');
+
+const traffic = {};
+let rulesTimer = null;
+
+function $(id) {
+  return document.getElementById(id);
+}
+
+function el(tag, attrs, ...children) {
+  const e = document.createElement(tag);
+  for (const [k, v] of Object.entries(attrs || {})) {
+    if (k === 'class') {
+      e.className = v;
+    } else {
+      e.setAttribute(k, v);
+    }
+  }
+  for (const c of children) {
+    e.append(c instanceof Node ? c : String(c));
+  }
+  return e;
+}
+
+function size(bytes) {
+  const units = ['B', 'K', 'M', 'G', 'T', 'P'];
+  let i = 0;
+  while (bytes >= 1024 && i < units.length - 1) {
+    bytes /= 1024;
+    i++;
+  }
+  return bytes.toFixed(i === 0 ? 0 : 1) + units[i];
+}
+
+function time(ms) {
+  return ms ? new Date(ms).toLocaleString() : '';
+}
+
+function bar(label, percent, detail) {
+  const level = percent >= 90 ? 'bad' : percent >= 70 ? 'warn' : '';
+  const fill = el('div', { class: 'fill ' + level });
+  fill.style.width = Math.min(Math.max(percent, 0), 100) + '%';
+  return el('div', { class: 'bar' },
+    el('div', { class: 'label' }, el('span', {}, label), el('span', {}, detail)),
+    el('div', { class: 'track' }, fill));
+}
+
+function table(id, head, rows) {
+  const t = $(id);
+  t.replaceChildren();
+  if (rows.length === 0) {
+    t.append(el('tr', {}, el('td', { class: 'empty' }, 'None')));
+    return;
+  }
+  t.append(el('tr', {}, ...head.map((h) => el('th', {}, h))));
+  for (const row of rows) {
+    t.append(el('tr', {}, ...row.map((c) => el('td', {}, c))));
+  }
+}
+
+function badge(state) {
+  return el('span', { class: 'badge ' + state }, state);
+}
+
+function renderStatus(s) {
+  $('name').textContent = s.name;
+  document.title = s.name + ' - ServerBox Monitor';
+  $('time').textContent = s.time ? 'Updated at ' + time(s.time) : 'Waiting for the first tick';
+
+  $('cpu').replaceChildren(...s.cpu.map((c) =>
+    c.ready ? bar(c.name, c.percent, c.percent.toFixed(1) + '%') : bar(c.name, 0, 'not ready')));
+
+  const mem = [];
+  if (s.mem) {
+    mem.push(bar('mem', s.mem.used / s.mem.total * 100, size(s.mem.used) + ' / ' + size(s.mem.total)));
+  }
+  if (s.swap && s.swap.total > 0) {
+    mem.push(bar('swap', s.swap.used / s.swap.total * 100, size(s.swap.used) + ' / ' + size(s.swap.total)));
+  }
+  $('mem').replaceChildren(...mem);
+
+  $('disk').replaceChildren(...s.disk.map((d) =>
+    bar(d.mount + ' (' + d.filesystem + ')', d.used_percent, size(d.used) + ' / ' + size(d.total))));
+
+  renderTraffic(s);
+
+  table('temp', ['Zone', 'Temperature'], s.temperature.map((t) => [t.name, t.value.toFixed(1) + '°C']));
+}
+
+function renderTraffic(s) {
+  const box = $('net');
+  const seen = new Set();
+  for (const n of s.network) {
+    seen.add(n.interface);
+    let t = traffic[n.interface];
+    if (!t) {
+      t = traffic[n.interface] = { points: [], label: el('div', { class: 'label' }), canvas: el('canvas') };
+      box.append(el('div', { class: 'iface' }, t.label, t.canvas));
+    }
+    if (n.ready) {
+      t.points.push([n.rx_speed, n.tx_speed]);
+      if (t.points.length > MAX_POINTS) {
+        t.points.shift();
+      }
+    }
+    t.label.replaceChildren(
+      el('span', {}, n.interface),
+      el('span', {},
+        el('span', { class: 'rx' }, '↓ ' + size(n.rx_speed) + '/s'), ' ',
+        el('span', { class: 'tx' }, '↑ ' + size(n.tx_speed) + '/s'), ' ',
+        '(total ' + size(n.rx) + ' / ' + size(n.tx) + ')'));
+    drawChart(t.canvas, t.points);
+  }
+  for (const name of Object.keys(traffic)) {
+    if (!seen.has(name)) {
+      traffic[name].canvas.parentNode.remove();
+      delete traffic[name];
+    }
+  }
+}
+
+function drawChart(canvas, points) {
+  const ratio = window.devicePixelRatio || 1;
+  const w = canvas.clientWidth * ratio;
+  const h = canvas.clientHeight * ratio;
+  canvas.width = w;
+  canvas.height = h;
+  const ctx = canvas.getContext('2d');
+  ctx.clearRect(0, 0, w, h);
+  if (points.length < 2) {
+    return;
+  }
+  const max = Math.max(1, ...points.map((p) => Math.max(p[0], p[1])));
+  const style = getComputedStyle(document.documentElement);
+  const colors = [style.getPropertyValue('--rx'), style.getPropertyValue('--tx')];
+  ctx.lineWidth = 1.5 * ratio;
+  for (let i = 0; i < 2; i++) {
+    ctx.strokeStyle = colors[i].trim();
+    ctx.beginPath();
+    points.forEach((p, j) => {
+      const x = w - (points.length - 1 - j) * w / (MAX_POINTS - 1);
+      const y = h - p[i] / max * (h - ratio * 2) - ratio;
+      if (j === 0) {
+        ctx.moveTo(x, y);
+      } else {
+        ctx.lineTo(x, y);
+      }
+    });
+    ctx.stroke();
+  }
+}
+
+async function get(path) {
+  const headers = TOKEN ? { Authorization: 'Bearer ' + TOKEN } : {};
+  const resp = await fetch(API + path, { headers });
+  const body = await resp.json();
+  if (!resp.ok) {
+    throw new Error(body.msg || resp.statusText);
+  }
+  return body.data;
+}
+
+// Active alerts are the rules which are not inactive
+async function loadRules() {
+  const rules = await get('rules');
+  const active = rules.filter((r) => r.state !== 'inactive');
+  table('alerts', ['Rule', 'State', 'Active at', 'Fired at'],
+    active.map((r) => [r.id, badge(r.state), time(r.active_at), time(r.fired_at)]));
+  table('rules', ['Rule', 'State', 'For', 'Clear'],
+    rules.map((r) => [r.id, badge(r.state), r.for || '', r.clear || '']));
+}
+
+async function loadPushes() {
+  const pushes = await get('pushes');
+  table('pushes', ['Name', 'Type', 'Resolved'],
+    pushes.map((p) => [p.name, p.type, p.resolved ? 'yes' : 'no']));
+}
+
+// Alerts of one tick come together, load rules once for them
+function reloadRules() {
+  clearTimeout(rulesTimer);
+  rulesTimer = setTimeout(() => loadRules().catch(console.error), 500);
+}
+
+function connect() {
+  const conn = $('conn');
+  // EventSource can't set headers
+  const query = TOKEN ? '?token=' + encodeURIComponent(TOKEN) : '';
+  const source = new EventSource(API + 'stream' + query);
+  source.onopen = () => {
+    conn.className = 'badge ok';
+    conn.textContent = 'live';
+  };
+  // EventSource reconnects by itself
+  source.onerror = () => {
+    conn.className = 'badge bad';
+    conn.textContent = 'disconnected';
+  };
+  source.addEventListener('status', (e) => renderStatus(JSON.parse(e.data)));
+  source.addEventListener('alert', reloadRules);
+}
+
+loadRules().catch(console.error);
+loadPushes().catch(console.error);
+connect();
@@ -0,0 +1,47 @@
+package runner
+
+import (
+	"sync"
+
+	"github.com/lollipopkit/server_box_monitor/web"
+)
+
+// counters are the internal counters exposed in /metrics.
+type counters struct {
+	lock        sync.Mutex
+	pushSuccess map[string]uint64
+	pushFailure map[string]uint64
+	ruleErrors  map[string]uint64
+}
+
+func newCounters() *counters {
+	return &counters{
+		pushSuccess: map[string]uint64{},
+		pushFailure: map[string]uint64{},
+		ruleErrors:  map[string]uint64{},
+	}
+}
+
+func (c *counters) inc(m map[string]uint64, key string) {
+	c.lock.Lock()
+	m[key]++
+	c.lock.Unlock()
+}
+
+func (c *counters) snapshot() web.Counters {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return web.Counters{
+		PushSuccess: copyCounts(c.pushSuccess),
+		PushFailure: copyCounts(c.pushFailure),
+		RuleErrors:  copyCounts(c.ruleErrors),
+	}
+}
+
+func copyCounts(m map[string]uint64) map[string]uint64 {
+	copied := make(map[string]uint64, len(m))
+	for k, v := range m {
+		copied[k] = v
+	}
+	return copied
+}
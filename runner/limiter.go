@@ -0,0 +1,63 @@
+package runner
+
+import (
+	"sync"
+	"time"
+)
+
+// pushLimiter limits pushes by name, same as rate.RateLimiter of gommon:
+// every name can be pushed maxCount times in one window of duration.
+// Unlike it, counts can be pruned when pushes are removed.
+type pushLimiter struct {
+	lock     sync.Mutex
+	duration time.Duration
+	maxCount int
+	// Start of the current window
+	start  time.Time
+	counts map[string]int
+}
+
+func newPushLimiter(duration time.Duration, maxCount int) *pushLimiter {
+	return &pushLimiter{
+		duration: duration,
+		maxCount: maxCount,
+		start:    time.Now(),
+		counts:   map[string]int{},
+	}
+}
+
+func (l *pushLimiter) check(name string) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.rotate()
+	return l.counts[name] < l.maxCount
+}
+
+func (l *pushLimiter) acquire(name string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.rotate()
+	l.counts[name]++
+}
+
+// update changes the rate, and only keeps the counts of names.
+func (l *pushLimiter) update(duration time.Duration, maxCount int, names []string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.duration = duration
+	l.maxCount = maxCount
+	counts := make(map[string]int, len(names))
+	for _, name := range names {
+		if count, ok := l.counts[name]; ok {
+			counts[name] = count
+		}
+	}
+	l.counts = counts
+}
+
+func (l *pushLimiter) rotate() {
+	if time.Since(l.start) > l.duration {
+		l.start = time.Now()
+		l.counts = map[string]int{}
+	}
+}
@@ -0,0 +1,450 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lollipopkit/gommon/log"
+	"github.com/lollipopkit/server_box_monitor/model"
+	"github.com/lollipopkit/server_box_monitor/res"
+	"github.com/lollipopkit/server_box_monitor/tsdb"
+	"github.com/lollipopkit/server_box_monitor/web"
+)
+
+var (
+	ErrNilConfig      = errors.New("config is nil")
+	ErrAlreadyStarted = errors.New("monitor already started")
+	// WebConfig.ClientCA is set without a valid cert and key
+	ErrClientCANeedsTLS = errors.New("client CA needs TLS cert and key")
+)
+
+// Monitor checks the status of one host by the rules in its config,
+// and pushes to the pushes in its config when rules match.
+// Several monitors can run side by side in one process.
+type Monitor struct {
+	// How long pushes queued before stopping can take to finish.
+	// Default is res.PushDrainTimeout.
+	DrainTimeout time.Duration
+	// Where UpdateConfig writes the config to, empty means not writing.
+	ConfigPath string
+
+	fs     *model.HostFS
+	config atomic.Pointer[model.AppConfig]
+	// Swapped as a whole every tick, readers always see one tick
+	status atomic.Pointer[model.ServerStatus]
+	// Notifies the check loop to pick up the new interval
+	reloaded chan struct{}
+	// Pending / firing state of rules
+	alerts *model.AlertTracker
+	// Recent values of all metrics
+	history *model.History
+	// Values are also written to it if it's not nil
+	store *tsdb.DB
+	// Push results and rule errors since started
+	counters *counters
+	// Ticks and alert changes are published to it
+	streams *streams
+
+	// Serializes UpdateConfig
+	updateLock sync.Mutex
+
+	limiter *pushLimiter
+
+	lock   sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// New creates a monitor from config.
+// fs can be nil, then model.DefaultHostFS is used.
+func New(config *model.AppConfig, fs *model.HostFS) (*Monitor, error) {
+	if config == nil {
+		return nil, ErrNilConfig
+	}
+	if fs == nil {
+		fs = model.DefaultHostFS
+	}
+	m := &Monitor{
+		DrainTimeout: res.PushDrainTimeout,
+		fs:           fs,
+		reloaded:     make(chan struct{}, 1),
+		alerts:       model.NewAlertTracker(),
+		history:      model.NewHistory(),
+		counters:     newCounters(),
+		streams:      newStreams(),
+		limiter:      newLimiter(config),
+	}
+	m.config.Store(config)
+	m.status.Store(new(model.ServerStatus))
+	return m, nil
+}
+
+func newLimiter(config *model.AppConfig) *pushLimiter {
+	limiter := config.GetRateLimiter()
+	return newPushLimiter(limiter.Duration, limiter.MaxCount)
+}
+
+// Reload validates config and applies it from the next tick.
+// The old config is kept if config is invalid.
+// Rate limit counts of pushes whose names didn't change are kept,
+// so are the states of rules which didn't change.
+func (m *Monitor) Reload(config *model.AppConfig) error {
+	if config == nil {
+		return ErrNilConfig
+	}
+	err := config.Validate()
+	if err != nil {
+		return err
+	}
+	m.apply(config)
+	return nil
+}
+
+// apply uses config from the next tick, it should be validated.
+func (m *Monitor) apply(config *model.AppConfig) {
+	// Counts are kept for the pushes whose names didn't change
+	limiter := config.GetRateLimiter()
+	names := make([]string, 0, len(config.Pushes))
+	for _, push := range config.Pushes {
+		names = append(names, push.Name)
+	}
+	m.limiter.update(limiter.Duration, limiter.MaxCount, names)
+
+	if m.store != nil {
+		raw, rollup := config.GetHistoryRetention()
+		m.store.SetRetention(tsdb.Retention{Raw: raw, Rollup: rollup})
+	}
+	m.config.Store(config)
+	m.alerts.Retain(config.Rules)
+	select {
+	case m.reloaded <- struct{}{}:
+	default:
+	}
+}
+
+// UpdateConfig applies fn to a copy of the config in use,
+// then validates it, writes it to m.ConfigPath and reloads it.
+// The config in use is not changed if any of them fails,
+// the error is model.ConfigErrors if the config is invalid.
+func (m *Monitor) UpdateConfig(fn func(config *model.AppConfig) error) (*model.AppConfig, error) {
+	m.updateLock.Lock()
+	defer m.updateLock.Unlock()
+
+	config, err := m.Config().Clone()
+	if err != nil {
+		return nil, err
+	}
+	err = fn(config)
+	if err != nil {
+		return nil, err
+	}
+	err = config.Validate()
+	if err != nil {
+		return nil, err
+	}
+	if m.ConfigPath != "" {
+		err = model.WriteAppConfig(m.ConfigPath, config)
+		if err != nil {
+			return nil, err
+		}
+	}
+	m.apply(config)
+	log.Info("[CONFIG] updated by API")
+	return config, nil
+}
+
+// UseStore writes the values of every tick to store,
+// and loads the recent values in store, so rules which look back
+// work right after restarting. It should be called before Start.
+func (m *Monitor) UseStore(store *tsdb.DB) error {
+	now := time.Now()
+	loaded := 0
+	err := store.Scan(now.Add(-res.MaxWindow), now, func(p tsdb.Point) {
+		m.history.Record(p.Key, p.Value, p.Time)
+		loaded++
+	})
+	if err != nil {
+		return err
+	}
+	log.Info("[HISTORY] %d values loaded", loaded)
+	m.store = store
+	return nil
+}
+
+// History returns the values of key in [from, to],
+// from the store if it's used, otherwise from the memory.
+func (m *Monitor) History(key string, from, to time.Time) ([]tsdb.Point, error) {
+	if m.store != nil {
+		return m.store.Query(key, from, to)
+	}
+	samples := m.history.Range(key, from, to)
+	points := make([]tsdb.Point, 0, len(samples))
+	for _, sample := range samples {
+		points = append(points, tsdb.Point{
+			Key:   key,
+			Time:  sample.Time,
+			Value: sample.Value,
+			Min:   sample.Value,
+			Max:   sample.Value,
+			Count: 1,
+		})
+	}
+	return points, nil
+}
+
+// Start runs the check loop in background until ctx is done or Stop is called.
+// Pushes queued before that are drained for at most m.DrainTimeout.
+func (m *Monitor) Start(ctx context.Context) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.cancel != nil {
+		return ErrAlreadyStarted
+	}
+	ctx, m.cancel = context.WithCancel(ctx)
+	m.done = make(chan struct{})
+	go m.run(ctx, m.done)
+	return nil
+}
+
+// Stop stops the check loop and waits for queued pushes to be drained.
+func (m *Monitor) Stop() {
+	m.lock.Lock()
+	cancel, done := m.cancel, m.done
+	m.cancel, m.done = nil, nil
+	m.lock.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
+
+func (m *Monitor) Name() string {
+	return m.config.Load().Name
+}
+
+// Config returns the config in use.
+// It is shared by all callers and should not be modified.
+func (m *Monitor) Config() *model.AppConfig {
+	return m.config.Load()
+}
+
+// Status returns the snapshot of the last tick.
+// It is shared by all callers and should not be modified.
+func (m *Monitor) Status() *model.ServerStatus {
+	return m.status.Load()
+}
+
+// Counters returns the counts of push results and rule errors.
+func (m *Monitor) Counters() web.Counters {
+	return m.counters.snapshot()
+}
+
+// Alerts returns the pending and firing alerts by model.Rule.Id().
+func (m *Monitor) Alerts() map[string]model.Alert {
+	return m.alerts.Active()
+}
+
+// Subscribe returns the statuses of ticks and the changes of alerts from now on.
+func (m *Monitor) Subscribe() (<-chan web.Event, func()) {
+	return m.streams.subscribe()
+}
+
+func (m *Monitor) run(ctx context.Context, done chan struct{}) {
+	defer close(done)
+
+	jobs := make(chan *pushJob, res.PushQueueSize)
+	// Cancels the pushes in flight when draining times out
+	pushCtx, cancelPush := context.WithCancel(context.Background())
+	defer cancelPush()
+	pushed := make(chan int, 1)
+	go m.runPush(pushCtx, jobs, pushed)
+
+	interval := m.Config().GetInterval()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			m.drain(jobs, pushed, cancelPush)
+			return
+		case <-m.reloaded:
+			if newInterval := m.Config().GetInterval(); newInterval != interval {
+				interval = newInterval
+				ticker.Reset(interval)
+				log.Info("[CONFIG] interval changed to %s", interval)
+			}
+		case <-ticker.C:
+			job := m.check()
+			if job.empty() {
+				continue
+			}
+			select {
+			case jobs <- job:
+			default:
+				log.Warn("[PUSH] queue is full, %d dropped", len(job.firing)+len(job.resolved))
+			}
+		}
+	}
+}
+
+// drain waits for the queued pushes at most m.DrainTimeout,
+// then cancels the rest, so no push outlives the monitor.
+func (m *Monitor) drain(jobs chan *pushJob, pushed <-chan int, cancelPush context.CancelFunc) {
+	close(jobs)
+	timer := time.NewTimer(m.DrainTimeout)
+	defer timer.Stop()
+	select {
+	case <-pushed:
+		return
+	case <-timer.C:
+	}
+	cancelPush()
+	dropped := <-pushed
+	log.Warn("[PUSH] drain timeout, %d pending pushes dropped", dropped)
+}
+
+// runPush pushes jobs until it's closed, then sends
+// how many jobs were dropped or interrupted by ctx to pushed.
+func (m *Monitor) runPush(ctx context.Context, jobs <-chan *pushJob, pushed chan<- int) {
+	dropped := 0
+	for job := range jobs {
+		if ctx.Err() != nil {
+			dropped++
+			continue
+		}
+		m.push(ctx, job)
+		if ctx.Err() != nil {
+			dropped++
+		}
+	}
+	pushed <- dropped
+}
+
+// pushJob is what to push after one tick.
+type pushJob struct {
+	firing []*model.PushPair
+	// Only pushed to the pushes which enable Push.Resolved
+	resolved []*model.PushPair
+}
+
+func (j *pushJob) empty() bool {
+	return j == nil || len(j.firing) == 0 && len(j.resolved) == 0
+}
+
+// check refreshes the status and returns what to push.
+func (m *Monitor) check() *pushJob {
+	// Collectors write into a copy, then it's published in one swap
+	status := m.status.Load().Clone()
+	err := status.Refresh(m.fs)
+	if err != nil {
+		log.Warn("[STATUS] Get status error: %v", err)
+		return nil
+	}
+	m.status.Store(status)
+	m.streams.publish(web.Event{Status: status})
+	values := m.history.RecordStatus(status)
+	if m.store != nil {
+		points := make([]tsdb.Point, 0, len(values))
+		for key, value := range values {
+			points = append(points, tsdb.Point{Key: key, Time: status.Time, Value: value})
+		}
+		if err := m.store.Append(points...); err != nil {
+			log.Warn("[HISTORY] write error: %v", err)
+		}
+	}
+
+	job := new(pushJob)
+	rules := m.Config().Rules
+	for i := range rules {
+		rule := &rules[i]
+		last := m.alerts.Get(rule)
+		var alert model.Alert
+		var pushPair *model.PushPair
+		result, err := rule.Check(status, m.history)
+		if err == nil {
+			pushPair = result.PushPair
+			alert, err = m.alerts.Update(rule, result.Reached, result.Cleared, status.Time)
+		}
+		if err != nil {
+			if !strings.Contains(err.Error(), model.ErrNotReady.Error()) {
+				log.Warn("[RULE] %s error: %v", rule.Id(), err)
+				m.counters.inc(m.counters.ruleErrors, rule.Id())
+			}
+			// The state is kept for a few checks, then it expires,
+			// or the alert of a removed device keeps firing
+			alert = m.alerts.Fail(rule, status.Time)
+			pushPair = nil
+			if alert.State == model.AlertStateResolved {
+				log.Warn("[RULE] %s can't be checked, its alert is resolved", rule.Id())
+				pushPair = model.NewPushPair(rule.Id(), "no data")
+			}
+		}
+		if alert.State != last.State {
+			event := &web.AlertEvent{
+				Rule:  rule.Id(),
+				State: alert.State.String(),
+				Time:  status.Time.UnixMilli(),
+			}
+			if pushPair != nil && alert.State != model.AlertStateInactive {
+				event.Value = pushPair.String()
+			}
+			m.streams.publish(web.Event{Alert: event})
+		}
+		if pushPair == nil {
+			continue
+		}
+
+		switch alert.State {
+		case model.AlertStateFiring:
+			job.firing = append(job.firing, pushPair)
+		case model.AlertStateResolved:
+			log.Info("[RULE] %s resolved after %s", rule.Id(), alert.Duration())
+			job.resolved = append(job.resolved, pushPair.Resolved(alert.Duration()))
+		}
+	}
+
+	return job
+}
+
+func (m *Monitor) push(ctx context.Context, job *pushJob) {
+	log.Info("[PUSH] %d firing, %d resolved to push", len(job.firing), len(job.resolved))
+
+	config := m.Config()
+	for _, push := range config.Pushes {
+		pushPairs := job.firing
+		// Resolved ones are not limited, they are never pushed again
+		// and the alert would look firing forever
+		limited := len(pushPairs) > 0
+		if limited && !m.limiter.check(push.Name) {
+			log.Warn("[PUSH] %s rate limit reached", push.Name)
+			pushPairs, limited = nil, false
+		}
+		if push.Resolved {
+			pushPairs = append(pushPairs[:len(pushPairs):len(pushPairs)], job.resolved...)
+		}
+		if len(pushPairs) == 0 {
+			continue
+		}
+		err := push.Push(ctx, config.Name, pushPairs)
+		if ctx.Err() != nil {
+			log.Warn("[PUSH] %s cancelled", push.Name)
+			return
+		}
+		if err != nil {
+			log.Warn("[PUSH] %s error: %v", push.Name, err)
+			m.counters.inc(m.counters.pushFailure, push.Name)
+			continue
+		}
+		// 仅推送成功才计数
+		if limited {
+			m.limiter.acquire(push.Name)
+		}
+		m.counters.inc(m.counters.pushSuccess, push.Name)
+		log.Suc("[PUSH] %s success", push.Name)
+	}
+}
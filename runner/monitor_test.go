@@ -0,0 +1,346 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lollipopkit/server_box_monitor/model"
+	"github.com/lollipopkit/server_box_monitor/res"
+	"github.com/lollipopkit/server_box_monitor/tsdb"
+	"github.com/lollipopkit/server_box_monitor/web"
+)
+
+var testHostFS = &model.HostFS{
+	Proc: "../model/test/proc",
+	Sys:  "../model/test/sys",
+	Root: "/",
+}
+
+func _newTestConfig(name string) *model.AppConfig {
+	return &model.AppConfig{
+		Name:     name,
+		Interval: "1s",
+		Rate:     "1/1m",
+		Rules: []model.Rule{
+			{MonitorType: model.MonitorTypeMemory, Threshold: ">=1%", Matcher: "used"},
+		},
+	}
+}
+
+func _newTestMonitor(t *testing.T, name string) *Monitor {
+	m, err := New(_newTestConfig(name), testHostFS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestMonitorsSideBySide(t *testing.T) {
+	m1 := _newTestMonitor(t, "m1")
+	m2 := _newTestMonitor(t, "m2")
+	for _, m := range []*Monitor{m1, m2} {
+		if err := m.Start(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.Start(context.Background()); err != ErrAlreadyStarted {
+			t.Errorf("expect ErrAlreadyStarted, got %v", err)
+		}
+	}
+
+	m1.check()
+	if m1.Status().Mem == nil {
+		t.Error("m1 should have mem status after check")
+	}
+	if m2.Status().Mem != nil {
+		t.Error("m2 should not share status with m1")
+	}
+	if m1.Name() != "m1" || m2.Name() != "m2" {
+		t.Errorf("unexpected names: %s, %s", m1.Name(), m2.Name())
+	}
+
+	m1.Stop()
+	m2.Stop()
+	// Stop twice is fine
+	m1.Stop()
+}
+
+func TestNewNilConfig(t *testing.T) {
+	if _, err := New(nil, nil); err != ErrNilConfig {
+		t.Errorf("expect ErrNilConfig, got %v", err)
+	}
+}
+
+// Run with `go test -race`
+func TestStatusSnapshotRace(t *testing.T) {
+	m := _newTestMonitor(t, "race")
+	e := echo.New()
+	handler := web.Status(m)
+
+	done := make(chan struct{})
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				s := m.Status()
+				for i := range s.CPU {
+					s.CPU[i].UsedPercent()
+				}
+				for _, d := range s.Disk {
+					_ = d.MountPath
+				}
+				for _, n := range s.Network {
+					n.ReceiveSpeed()
+				}
+				if s.Mem != nil && s.Mem.Used > s.Mem.Total {
+					t.Error("mem used is bigger than total")
+				}
+
+				rec := httptest.NewRecorder()
+				c := e.NewContext(httptest.NewRequest(http.MethodGet, "/status", nil), rec)
+				if err := handler(c); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 50; i++ {
+		before := m.Status()
+		m.check()
+		if m.Status() == before {
+			t.Fatal("status should be swapped after check")
+		}
+	}
+	close(done)
+	wg.Wait()
+}
+
+func _newPushMonitor(t *testing.T, url string) *Monitor {
+	config := _newTestConfig("push")
+	iface, err := json.Marshal(model.PushIfaceWebhook{
+		Url:    url,
+		Method: "POST",
+		Body:   json.RawMessage(`"{{msg}}"`),
+		Code:   200,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Pushes = []model.Push{{Type: model.PushTypeWebhook, Name: "hook", Iface: iface}}
+	m, err := New(config, testHostFS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestStopDrainsPushes(t *testing.T) {
+	received := make(chan struct{}, 1)
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- struct{}{}
+		<-release
+	}))
+	defer srv.Close()
+
+	m := _newPushMonitor(t, srv.URL)
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// The push is in flight after the first tick
+	<-received
+	stopped := make(chan struct{})
+	go func() {
+		m.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		t.Fatal("stop should wait for the push in flight")
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(release)
+	<-stopped
+}
+
+func TestStopDrainTimeout(t *testing.T) {
+	received := make(chan struct{}, 1)
+	cancelled := make(chan struct{}, 1)
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Disconnecting is only noticed after the body is read
+		io.ReadAll(r.Body)
+		received <- struct{}{}
+		select {
+		case <-release:
+		case <-r.Context().Done():
+			cancelled <- struct{}{}
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	m := _newPushMonitor(t, srv.URL)
+	m.DrainTimeout = 100 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := m.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	<-received
+	cancel()
+	start := time.Now()
+	m.Stop()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("stop should give up after drain timeout, took %s", elapsed)
+	}
+	// The push in flight doesn't outlive the monitor
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Error("push in flight should be cancelled after drain timeout")
+	}
+}
+
+func TestPushResolvedOptIn(t *testing.T) {
+	bodies := make(chan string, 2)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body string
+		json.NewDecoder(r.Body).Decode(&body)
+		bodies <- r.URL.Path + " " + body
+	}))
+	defer srv.Close()
+
+	m := _newPushMonitor(t, srv.URL)
+	config := *m.Config()
+	optIn := config.Pushes[0]
+	optIn.Name = "resolved"
+	optIn.Resolved = true
+	optIn.Iface = json.RawMessage(strings.Replace(string(optIn.Iface), srv.URL, srv.URL+"/resolved", 1))
+	config.Pushes = append(config.Pushes, optIn)
+	if err := m.Reload(&config); err != nil {
+		t.Fatal(err)
+	}
+
+	pair := model.NewPushPair("cpu", "12.00%")
+	m.push(context.Background(), &pushJob{resolved: []*model.PushPair{pair.Resolved(3 * time.Minute)}})
+	select {
+	case body := <-bodies:
+		expect := "/resolved cpu: 12.00% (resolved, lasted 3m0s)"
+		if body != expect {
+			t.Errorf("expect %q, got %q", expect, body)
+		}
+	default:
+		t.Fatal("expect the resolved push")
+	}
+	select {
+	case body := <-bodies:
+		t.Errorf("push without resolved should be skipped, got %q", body)
+	default:
+	}
+}
+
+func TestPushResolvedNotLimited(t *testing.T) {
+	bodies := make(chan string, 3)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body string
+		json.NewDecoder(r.Body).Decode(&body)
+		bodies <- body
+	}))
+	defer srv.Close()
+
+	m := _newPushMonitor(t, srv.URL)
+	config := *m.Config()
+	config.Pushes[0].Resolved = true
+	if err := m.Reload(&config); err != nil {
+		t.Fatal(err)
+	}
+
+	// Fires and resolves in one window of the rate 1/1m
+	pair := model.NewPushPair("cpu", "92.00%")
+	m.push(context.Background(), &pushJob{firing: []*model.PushPair{pair}})
+	m.push(context.Background(), &pushJob{firing: []*model.PushPair{pair}})
+	m.push(context.Background(), &pushJob{resolved: []*model.PushPair{pair.Resolved(time.Second)}})
+	close(bodies)
+	got := []string{}
+	for body := range bodies {
+		got = append(got, body)
+	}
+	expect := []string{"cpu: 92.00%", "cpu: 92.00% (resolved, lasted 1s)"}
+	if strings.Join(got, "\n") != strings.Join(expect, "\n") {
+		t.Errorf("expect %q, got %q", expect, got)
+	}
+}
+
+func TestUseStore(t *testing.T) {
+	store, err := tsdb.Open(t.TempDir(), tsdb.Retention{Raw: time.Hour, Rollup: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	before := time.Now().Add(-time.Minute)
+	if err := store.Append(tsdb.Point{Key: "mem/used/percent", Time: before, Value: 42}); err != nil {
+		t.Fatal(err)
+	}
+
+	m := _newTestMonitor(t, "store")
+	if err := m.UseStore(store); err != nil {
+		t.Fatal(err)
+	}
+	if samples := m.history.Window("mem/used/percent", time.Now(), 2*time.Minute); len(samples) != 1 || samples[0].Value != 42 {
+		t.Errorf("expect the value in store to be loaded, got %v", samples)
+	}
+
+	m.check()
+	points, err := store.Query("mem/used/percent", before, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 2 {
+		t.Errorf("expect the value of the tick to be written, got %+v", points)
+	}
+}
+
+func TestCheckStaleAlert(t *testing.T) {
+	config := _newTestConfig("stale")
+	config.Rules = []model.Rule{{MonitorType: model.MonitorTypeTemperature, Threshold: ">1c", Matcher: "thermal_zone1"}}
+	m, err := New(config, testHostFS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job := m.check(); len(job.firing) != 1 {
+		t.Fatalf("expect firing, got %+v", job)
+	}
+
+	// The zone is gone
+	m.fs = &model.HostFS{Proc: testHostFS.Proc, Sys: t.TempDir(), Root: testHostFS.Root}
+	for i := 1; i < res.RuleStaleChecks; i++ {
+		if job := m.check(); !job.empty() {
+			t.Fatalf("check %d: expect nothing to push, got %+v", i, job)
+		}
+		if state := m.alerts.Get(&config.Rules[0]).State; state != model.AlertStateFiring {
+			t.Fatalf("check %d: expect firing to be kept, got %s", i, state)
+		}
+	}
+	job := m.check()
+	if len(job.resolved) != 1 || !strings.Contains(job.resolved[0].String(), "no data (resolved") {
+		t.Errorf("expect the alert to be resolved, got %+v", job)
+	}
+	if state := m.alerts.Get(&config.Rules[0]).State; state != model.AlertStateInactive {
+		t.Errorf("expect inactive, got %s", state)
+	}
+}
@@ -0,0 +1,66 @@
+package runner
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/lollipopkit/gommon/log"
+	"github.com/lollipopkit/server_box_monitor/model"
+)
+
+// watchConfig reloads m from path on SIGHUP,
+// or when the file in path is modified.
+// The file is polled every interval.
+func watchConfig(ctx context.Context, m *Monitor, path string, interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	modTime, size := statConfig(path)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			log.Info("[CONFIG] SIGHUP received, reloading")
+			modTime, size = statConfig(path)
+			reloadConfig(m, path)
+		case <-ticker.C:
+			newModTime, newSize := statConfig(path)
+			if newModTime.Equal(modTime) && newSize == size {
+				continue
+			}
+			modTime, size = newModTime, newSize
+			log.Info("[CONFIG] %s changed, reloading", path)
+			reloadConfig(m, path)
+		}
+	}
+}
+
+func statConfig(path string) (time.Time, int64) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, 0
+	}
+	return info.ModTime(), info.Size()
+}
+
+func reloadConfig(m *Monitor, path string) {
+	config, err := model.LoadAppConfig(path)
+	if err != nil {
+		log.Warn("[CONFIG] reload failed, keep the old config: %v", err)
+		return
+	}
+	err = m.Reload(config)
+	if err != nil {
+		log.Warn("[CONFIG] reload failed, keep the old config: %v", err)
+		return
+	}
+	log.Suc("[CONFIG] reloaded")
+}
@@ -0,0 +1,89 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lollipopkit/server_box_monitor/model"
+)
+
+func TestReloadKeepsRateLimit(t *testing.T) {
+	m := _newTestMonitor(t, "old")
+	m.limiter.acquire("kept")
+	m.limiter.acquire("removed")
+
+	config := _newTestConfig("new")
+	config.Rate = "1/10m"
+	iface := json.RawMessage(`{"url": "http://localhost", "method": "POST"}`)
+	config.Pushes = []model.Push{
+		{Type: model.PushTypeWebhook, Name: "kept", Iface: iface},
+		{Type: model.PushTypeWebhook, Name: "added", Iface: iface},
+	}
+	if err := m.Reload(config); err != nil {
+		t.Fatal(err)
+	}
+	if m.Name() != "new" {
+		t.Errorf("expect new name, got %s", m.Name())
+	}
+	if m.limiter.check("kept") {
+		t.Error("rate limit of an unchanged push should be kept")
+	}
+	if !m.limiter.check("added") {
+		t.Error("a new push should not be limited")
+	}
+	if _, ok := m.limiter.counts["removed"]; ok {
+		t.Error("count of a removed push should be pruned")
+	}
+	if m.limiter.duration != 10*time.Minute {
+		t.Errorf("expect new rate duration, got %s", m.limiter.duration)
+	}
+}
+
+func TestReloadInvalidKeepsOld(t *testing.T) {
+	m := _newTestMonitor(t, "old")
+	config := _newTestConfig("new")
+	config.Rules[0].Threshold = ">=77"
+	err := m.Reload(config)
+	if !errors.Is(err, model.ErrInvalidConfig) {
+		t.Errorf("expect ErrInvalidConfig, got %v", err)
+	}
+	if m.Name() != "old" {
+		t.Errorf("old config should be kept, got %s", m.Name())
+	}
+}
+
+func TestWatchConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	_writeConfig(t, path, _newTestConfig("old"))
+	m := _newTestMonitor(t, "old")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go watchConfig(ctx, m, path, 10*time.Millisecond)
+
+	// Make sure mtime changes on coarse filesystems
+	time.Sleep(20 * time.Millisecond)
+	_writeConfig(t, path, _newTestConfig("changed"))
+	deadline := time.Now().Add(time.Second)
+	for m.Name() != "changed" {
+		if time.Now().After(deadline) {
+			t.Fatal("config is not reloaded after file changed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func _writeConfig(t *testing.T, path string, config *model.AppConfig) {
+	data, err := json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
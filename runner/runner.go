@@ -1,121 +1,181 @@
 package runner
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
 	"os"
 	"strings"
-	"sync"
-	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	"github.com/lollipopkit/gommon/log"
 	"github.com/lollipopkit/server_box_monitor/model"
 	"github.com/lollipopkit/server_box_monitor/res"
+	"github.com/lollipopkit/server_box_monitor/tsdb"
 	"github.com/lollipopkit/server_box_monitor/web"
 )
 
-var (
-	pushPairs     = []*model.PushPair{}
-	pushPairsLock = new(sync.RWMutex)
-)
-
-func init() {
-	scriptBytes, err := res.Files.ReadFile(res.ServerBoxShellFileName)
+// Start runs a monitor of the host in fs with the config in res.AppConfigPath,
+// and serves its status on wc. fs can be nil, then model.DefaultHostFS is used.
+// It blocks until ctx is done, then shuts down the web server
+// and drains the pushes in flight.
+func Start(ctx context.Context, wc *model.WebConfig, fs *model.HostFS) error {
+	config, err := model.ReadAppConfig()
 	if err != nil {
-		log.Err("[INIT] Read embed file error: %v", err)
-		panic(err)
+		log.Err("[CONFIG] Read app config error: %v", err)
+		return err
 	}
-	err = os.WriteFile(res.ServerBoxShellPath, scriptBytes, 0755)
+	err = config.Validate()
 	if err != nil {
-		log.Err("[INIT] Write script file error: %v", err)
-		panic(err)
+		log.Err("[CONFIG] Invalid app config: %v", err)
+		return err
 	}
-}
-
-func Start(wc *model.WebConfig) {
-	go runWeb(wc)
-	go runCheck()
-	// 阻塞主线程
-	select {}
-}
-
-func runCheck() {
-	err := model.ReadAppConfig()
+	if !config.Auth.Enabled() {
+		log.Warn("[WEB] Auth is not enabled, everyone who can reach %s can read the status", wc.Addr)
+	}
+	m, err := New(config, fs)
 	if err != nil {
-		log.Err("[CONFIG] Read app config error: %v", err)
-		panic(err)
+		return err
 	}
-
-	for range time.NewTicker(model.CheckInterval).C {
-		err = model.RefreshStatus()
-		status := model.Status
+	m.ConfigPath = res.AppConfigPath
+	if !config.HistoryDisabled() {
+		raw, rollup := config.GetHistoryRetention()
+		store, err := tsdb.Open(res.HistoryDirPath, tsdb.Retention{Raw: raw, Rollup: rollup})
 		if err != nil {
-			log.Warn("[STATUS] Get status error: %v", err)
-			continue
+			log.Err("[HISTORY] Open error: %v", err)
+			return err
 		}
-
-		for _, rule := range model.Config.Rules {
-			notify, pushPair, err := rule.ShouldNotify(status)
-			if err != nil {
-				if !strings.Contains(err.Error(), model.ErrNotReady.Error()) {
-					log.Warn("[RULE] %s error: %v", rule.Id(), err)
-				}
-			}
-
-			if notify && pushPair != nil {
-				pushPairsLock.Lock()
-				pushPairs = append(pushPairs, pushPair)
-				pushPairsLock.Unlock()
-			}
-		}
-
-		if len(pushPairs) == 0 {
-			continue
-		}
-
-		log.Info("[PUSH] %d to push", len(pushPairs))
-
-		for _, push := range model.Config.Pushes {
-			if !model.RateLimiter.Check(push.Name) {
-				log.Warn("[PUSH] %s rate limit reached", push.Name)
-				continue
-			}
-			pushPairsLock.RLock()
-			err := push.Push(pushPairs)
-			pushPairsLock.RUnlock()
-			if err != nil {
-				log.Warn("[PUSH] %s error: %v", push.Name, err)
-				continue
-			}
-			// 仅推送成功才计数
-			model.RateLimiter.Acquire(push.Name)
-			log.Suc("[PUSH] %s success", push.Name)
-
+		// Closed after the monitor stops
+		defer store.Close()
+		err = m.UseStore(store)
+		if err != nil {
+			log.Warn("[HISTORY] Load error: %v", err)
 		}
+	}
+	err = m.Start(ctx)
+	if err != nil {
+		return err
+	}
+	defer m.Stop()
+	go watchConfig(ctx, m, res.AppConfigPath, res.ConfigWatchInterval)
+
+	e := newWeb(m)
+	webErr := make(chan error, 1)
+	go func() {
+		webErr <- runWeb(e, wc)
+	}()
+
+	select {
+	case err = <-webErr:
+		log.Err("[WEB] Serve error: %v", err)
+		return err
+	case <-ctx.Done():
+	}
 
-		pushPairsLock.Lock()
-		pushPairs = pushPairs[:0]
-		pushPairsLock.Unlock()
+	log.Info("[EXIT] Shutting down")
+	// Streams never end by themselves
+	m.streams.close()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), res.WebShutdownTimeout)
+	defer cancel()
+	if err := e.Shutdown(shutdownCtx); err != nil {
+		log.Warn("[WEB] Shutdown error: %v", err)
 	}
+	return nil
 }
 
-func runWeb(wc *model.WebConfig) {
+func newWeb(m *Monitor) *echo.Echo {
 	e := echo.New()
 
 	e.Use(middleware.Recover())
-	e.Use(middleware.RateLimiter(middleware.NewRateLimiterMemoryStore(3)))
+	e.Use(middleware.RateLimiterWithConfig(middleware.RateLimiterConfig{
+		// One stream replaces polling, and the UI loads files at once
+		Skipper: func(c echo.Context) bool {
+			return c.Path() == "/api/v1/stream" || strings.HasPrefix(c.Path(), "/ui")
+		},
+		Store: middleware.NewRateLimiterMemoryStore(3),
+	}))
 	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
-		AllowOrigins: []string{},
+		AllowOriginFunc: web.AllowOrigin(m),
+	}))
+	// After CORS, preflight requests don't have credentials
+	e.Use(web.Authenticate(m, web.AuthenticateConfig{
+		Skipper: func(c echo.Context) bool {
+			return c.Path() == "/" || strings.HasPrefix(c.Path(), "/ui")
+		},
+		QueryTokenRoutes: []string{"/api/v1/stream"},
 	}))
 	e.HideBanner = true
 
-	e.GET("/status", web.Status)
+	e.GET("/", func(c echo.Context) error {
+		return c.Redirect(http.StatusFound, "/ui/")
+	})
+	e.StaticFS("/ui", echo.MustSubFS(res.Files, "ui"))
+
+	e.GET("/status", web.Status(m))
+	e.GET("/api/v1/status", web.Snapshot(m))
+	e.GET("/api/v1/history", web.History(m))
+	e.GET("/api/v1/stream", web.Stream(m))
+	e.GET("/api/v1/alerts", web.Alerts(m))
+	e.GET("/api/v1/rules", web.Rules(m))
+	e.GET("/api/v1/pushes", web.Pushes(m))
+	e.GET("/api/v1/settings", web.Settings(m))
+
+	admin := web.RequireScope(model.AuthScopeAdmin)
+	e.POST("/api/v1/rules", web.CreateRule(m), admin)
+	e.PUT("/api/v1/rules/:id", web.UpdateRule(m), admin)
+	e.DELETE("/api/v1/rules/:id", web.DeleteRule(m), admin)
+	e.POST("/api/v1/pushes", web.CreatePush(m), admin)
+	e.PUT("/api/v1/pushes/:name", web.UpdatePush(m), admin)
+	e.DELETE("/api/v1/pushes/:name", web.DeletePush(m), admin)
+	e.PUT("/api/v1/settings", web.UpdateSettings(m), admin)
+	e.GET("/metrics", web.Metrics(m))
+	return e
+}
+
+func runWeb(e *echo.Echo, wc *model.WebConfig) error {
+	var err error
+	switch {
+	case wc.ClientCA != "":
+		err = startMutualTLS(e, wc)
+	case wc.HaveTLS():
+		err = e.StartTLS(wc.Addr, wc.Cert, wc.Key)
+	default:
+		err = e.Start(wc.Addr)
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
 
-	var i any
-	if wc.HaveTLS() {
-		i = e.StartTLS(wc.Addr, wc.Cert, wc.Key)
-	} else {
-		i = e.Start(wc.Addr)
+// startMutualTLS only accepts clients with a certificate signed by wc.ClientCA.
+func startMutualTLS(e *echo.Echo, wc *model.WebConfig) error {
+	if !wc.HaveTLS() {
+		return ErrClientCANeedsTLS
+	}
+	cert, err := tls.LoadX509KeyPair(wc.Cert, wc.Key)
+	if err != nil {
+		return err
+	}
+	caPEM, err := os.ReadFile(wc.ClientCA)
+	if err != nil {
+		return err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return fmt.Errorf("no certificate found in %s", wc.ClientCA)
+	}
+	// Uses e.TLSServer, so e.Shutdown works
+	e.TLSServer.Addr = wc.Addr
+	e.TLSServer.TLSConfig = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
 	}
-	e.Logger.Fatal(i)
+	return e.StartServer(e.TLSServer)
 }
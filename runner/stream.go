@@ -0,0 +1,68 @@
+package runner
+
+import (
+	"sync"
+
+	"github.com/lollipopkit/gommon/log"
+	"github.com/lollipopkit/server_box_monitor/res"
+	"github.com/lollipopkit/server_box_monitor/web"
+)
+
+// streams fans out events to stream clients.
+// Publishing never blocks the check loop,
+// a client whose buffer is full is dropped and should reconnect.
+type streams struct {
+	lock   sync.Mutex
+	subs   map[chan web.Event]struct{}
+	closed bool
+}
+
+func newStreams() *streams {
+	return &streams{subs: map[chan web.Event]struct{}{}}
+}
+
+func (s *streams) subscribe() (<-chan web.Event, func()) {
+	ch := make(chan web.Event, res.StreamBufferSize)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	s.subs[ch] = struct{}{}
+	return ch, func() {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		s.remove(ch)
+	}
+}
+
+func (s *streams) publish(event web.Event) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for ch := range s.subs {
+		select {
+		case ch <- event:
+		default:
+			log.Warn("[STREAM] client is too slow, dropped")
+			s.remove(ch)
+		}
+	}
+}
+
+// close ends all streams, and new ones end at once.
+func (s *streams) close() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.closed = true
+	for ch := range s.subs {
+		s.remove(ch)
+	}
+}
+
+func (s *streams) remove(ch chan web.Event) {
+	if _, ok := s.subs[ch]; ok {
+		delete(s.subs, ch)
+		close(ch)
+	}
+}
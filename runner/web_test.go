@@ -0,0 +1,550 @@
+package runner
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lollipopkit/server_box_monitor/model"
+	"github.com/lollipopkit/server_box_monitor/res"
+	"github.com/lollipopkit/server_box_monitor/tsdb"
+	"github.com/lollipopkit/server_box_monitor/web"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func _get(t *testing.T, m *Monitor, target string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	newWeb(m).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	return rec
+}
+
+func TestHistoryAPI(t *testing.T) {
+	m := _newTestMonitor(t, "history")
+	m.check()
+	m.check()
+
+	rec := _get(t, m, "/api/v1/history?metric=mem&matcher=used&unit=percent&step=1m")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expect 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var resp struct {
+		Data struct {
+			Unit   string `json:"unit"`
+			Step   string `json:"step"`
+			Points []struct {
+				Value float64 `json:"value"`
+			} `json:"points"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Data.Unit != "percent" || resp.Data.Step != "1m0s" {
+		t.Errorf("unexpected resp: %s", rec.Body)
+	}
+	// Two ticks in one step
+	if len(resp.Data.Points) != 1 || resp.Data.Points[0].Value <= 0 {
+		t.Errorf("expect one averaged point, got %s", rec.Body)
+	}
+
+	for _, target := range []string{
+		"/api/v1/history?metric=gpu",
+		"/api/v1/history?metric=mem",
+		"/api/v1/history?metric=mem&matcher=mem",
+		"/api/v1/history?metric=cpu&unit=size",
+		"/api/v1/history?metric=cpu&from=yesterday",
+		"/api/v1/history?metric=cpu&from=2000&to=1000",
+		"/api/v1/history?metric=cpu&step=-1m",
+	} {
+		if rec := _get(t, m, target); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expect 400, got %d", target, rec.Code)
+		}
+	}
+	// The matcher of cpu defaults to all CPUs
+	if rec := _get(t, m, "/api/v1/history?metric=cpu"); rec.Code != http.StatusOK {
+		t.Errorf("expect 200 without the matcher of cpu, got %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestHistoryAPIWeighted(t *testing.T) {
+	store, err := tsdb.Open(t.TempDir(), tsdb.Retention{Raw: time.Minute, Rollup: 24 * time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	const key = "mem/used/percent"
+	from := time.Now().Add(-time.Hour).Truncate(2 * time.Minute)
+	// 3 points in the first minute, 1 in the second,
+	// the last one flushes the rollup of the second minute.
+	for i, p := range []struct {
+		after time.Duration
+		value float64
+	}{{0, 0}, {10 * time.Second, 0}, {20 * time.Second, 0}, {time.Minute, 4}, {2 * time.Minute, 100}} {
+		if err := store.Append(tsdb.Point{Key: key, Time: from.Add(p.after), Value: p.value}); err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+	}
+	m := _newTestMonitor(t, "weighted")
+	if err := m.UseStore(store); err != nil {
+		t.Fatal(err)
+	}
+
+	target := fmt.Sprintf("/api/v1/history?metric=mem&matcher=used&unit=percent&step=2m&from=%d&to=%d",
+		from.Unix(), from.Add(2*time.Minute-time.Second).Unix())
+	rec := _get(t, m, target)
+	var resp struct {
+		Data struct {
+			Points []struct {
+				Value float64 `json:"value"`
+			} `json:"points"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	// (0 * 3 + 4 * 1) / 4
+	if len(resp.Data.Points) != 1 || resp.Data.Points[0].Value != 1 {
+		t.Errorf("expect the average weighted by counts, got %s", rec.Body)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	m := _newTestMonitor(t, "metrics")
+	m.check()
+	m.check()
+	m.counters.inc(m.counters.pushFailure, `bark "home"`)
+
+	rec := _get(t, m, "/metrics")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expect 200, got %d: %s", rec.Code, rec.Body)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("unexpected content type: %s", ct)
+	}
+	body := rec.Body.String()
+	for _, expect := range []string{
+		"# TYPE server_box_cpu_usage_percent gauge\n",
+		`server_box_memory_bytes{type="total"} `,
+		`server_box_disk_bytes{mount="/",`,
+		"# TYPE server_box_network_bytes_total counter\n",
+		"# TYPE server_box_network_speed_bytes_per_second gauge\n",
+		`server_box_push_total{push="bark \"home\"",result="failure"} 1` + "\n",
+		// Two zones are acpitz in the fixture
+		`server_box_temperature_celsius{zone="thermal_zone0",type="acpitz"} `,
+		`server_box_temperature_celsius{zone="thermal_zone2",type="acpitz"} `,
+	} {
+		if !strings.Contains(body, expect) {
+			t.Errorf("expect %q in:\n%s", expect, body)
+		}
+	}
+	// Prometheus rejects the scrape with duplicate series
+	series := map[string]bool{}
+	for _, line := range strings.Split(body, "\n") {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name := line[:strings.LastIndex(line, " ")]
+		if series[name] {
+			t.Errorf("duplicate series: %s", name)
+		}
+		series[name] = true
+	}
+}
+
+func TestSnapshotAPI(t *testing.T) {
+	m := _newTestMonitor(t, "snapshot")
+	var resp struct {
+		Data struct {
+			Name  string `json:"name"`
+			Time  int64  `json:"time"`
+			Ready bool   `json:"ready"`
+			CPU   []struct {
+				Name  string `json:"name"`
+				Ready bool   `json:"ready"`
+			} `json:"cpu"`
+			Mem *struct {
+				Total int64 `json:"total"`
+			} `json:"mem"`
+			Disk []struct {
+				Mount string `json:"mount"`
+				Total int64  `json:"total"`
+			} `json:"disk"`
+			Network []struct {
+				Interface string `json:"interface"`
+				Receive   int64  `json:"rx"`
+			} `json:"network"`
+		} `json:"data"`
+	}
+
+	rec := _get(t, m, "/api/v1/status")
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Data.Ready || resp.Data.Time != 0 || resp.Data.Mem != nil {
+		t.Errorf("expect not ready before the first tick, got %s", rec.Body)
+	}
+
+	m.check()
+	m.check()
+	rec = _get(t, m, "/api/v1/status")
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	data := resp.Data
+	if data.Name != "snapshot" || data.Time == 0 {
+		t.Errorf("unexpected resp: %s", rec.Body)
+	}
+	if len(data.CPU) == 0 || data.CPU[0].Name != "cpu" {
+		t.Errorf("unexpected cpu: %s", rec.Body)
+	}
+	if data.Mem == nil || data.Mem.Total <= 0 {
+		t.Errorf("unexpected mem: %s", rec.Body)
+	}
+	if len(data.Disk) == 0 || data.Disk[0].Mount != "/" || data.Disk[0].Total <= 0 {
+		t.Errorf("unexpected disk: %s", rec.Body)
+	}
+	if len(data.Network) == 0 || data.Network[0].Receive <= 0 {
+		t.Errorf("unexpected network: %s", rec.Body)
+	}
+}
+
+func TestStream(t *testing.T) {
+	m := _newTestMonitor(t, "stream")
+	server := httptest.NewServer(newWeb(m))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/api/v1/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("unexpected content type: %s", ct)
+	}
+	reader := bufio.NewReader(resp.Body)
+	readEvent := func() string {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		// data and the blank line
+		for i := 0; i < 2; i++ {
+			if _, err := reader.ReadString('\n'); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return strings.TrimSpace(line)
+	}
+
+	// Current status first
+	if event := readEvent(); event != "event: status" {
+		t.Fatalf("expect status, got %q", event)
+	}
+	m.check()
+	if event := readEvent(); event != "event: status" {
+		t.Fatalf("expect status, got %q", event)
+	}
+
+	// Closed streams end the response
+	m.streams.close()
+	if _, err := io.ReadAll(reader); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStreamSlowClient(t *testing.T) {
+	s := newStreams()
+	slow, cancelSlow := s.subscribe()
+	defer cancelSlow()
+	fast, cancelFast := s.subscribe()
+	defer cancelFast()
+
+	for i := 0; i <= res.StreamBufferSize; i++ {
+		s.publish(web.Event{Status: new(model.ServerStatus)})
+		<-fast
+	}
+	// Buffered events are still delivered before it's closed
+	count := 0
+	for range slow {
+		count++
+	}
+	if count != res.StreamBufferSize {
+		t.Errorf("expect %d events, got %d", res.StreamBufferSize, count)
+	}
+
+	s.publish(web.Event{Status: new(model.ServerStatus)})
+	if _, ok := <-fast; !ok {
+		t.Error("fast client should not be dropped")
+	}
+}
+
+func TestUI(t *testing.T) {
+	m := _newTestMonitor(t, "ui")
+	if rec := _get(t, m, "/"); rec.Code != http.StatusFound || rec.Header().Get("Location") != "/ui/" {
+		t.Errorf("expect redirect to /ui/, got %d %s", rec.Code, rec.Header().Get("Location"))
+	}
+	for _, target := range []string{"/ui/", "/ui/app.js", "/ui/style.css"} {
+		if rec := _get(t, m, target); rec.Code != http.StatusOK {
+			t.Errorf("%s: expect 200, got %d", target, rec.Code)
+		}
+	}
+	// Nothing is loaded from outside
+	for _, name := range []string{"ui/index.html", "ui/app.js", "ui/style.css"} {
+		file, err := res.Files.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(file), "http://") || strings.Contains(string(file), "https://") {
+			t.Errorf("%s should not have external urls", name)
+		}
+	}
+}
+
+func TestRulesAPI(t *testing.T) {
+	config := _newTestConfig("rules")
+	config.Pushes = []model.Push{{
+		Type:  model.PushTypeBark,
+		Name:  "bark",
+		Iface: json.RawMessage(`{"key": "secret"}`),
+	}}
+	m, err := New(config, testHostFS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.check()
+
+	var rules struct {
+		Data []struct {
+			Id    string `json:"id"`
+			Type  string `json:"type"`
+			State string `json:"state"`
+		} `json:"data"`
+	}
+	rec := _get(t, m, "/api/v1/rules")
+	if err := json.Unmarshal(rec.Body.Bytes(), &rules); err != nil {
+		t.Fatal(err)
+	}
+	if len(rules.Data) != 1 || rules.Data[0].Type != "mem" || rules.Data[0].State != "firing" {
+		t.Errorf("unexpected rules: %s", rec.Body)
+	}
+
+	var alerts struct {
+		Data []struct {
+			Rule    string `json:"rule"`
+			FiredAt int64  `json:"fired_at"`
+		} `json:"data"`
+	}
+	rec = _get(t, m, "/api/v1/alerts")
+	if err := json.Unmarshal(rec.Body.Bytes(), &alerts); err != nil {
+		t.Fatal(err)
+	}
+	if len(alerts.Data) != 1 || alerts.Data[0].Rule != rules.Data[0].Id || alerts.Data[0].FiredAt == 0 {
+		t.Errorf("unexpected alerts: %s", rec.Body)
+	}
+
+	rec = _get(t, m, "/api/v1/pushes")
+	if !strings.Contains(rec.Body.String(), `"name":"bark"`) || strings.Contains(rec.Body.String(), "secret") {
+		t.Errorf("unexpected pushes: %s", rec.Body)
+	}
+}
+
+func TestAuth(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("pass"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := _newTestConfig("auth")
+	config.Auth = &model.AuthConfig{
+		Tokens:  []model.AuthToken{{Token: "0123456789abcdef"}},
+		Users:   []model.AuthUser{{Name: "admin", Hash: string(hash), Scope: model.AuthScopeAdmin}},
+		Origins: []string{"https://example.com"},
+	}
+	m, err := New(config, testHostFS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := newWeb(m)
+	e.GET("/admin", func(c echo.Context) error {
+		return c.NoContent(http.StatusNoContent)
+	}, web.RequireScope(model.AuthScopeAdmin))
+
+	clients := 0
+	do := func(target string, setup func(r *http.Request)) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		// Not limited by the rate limiter
+		clients++
+		req.RemoteAddr = fmt.Sprintf("10.0.0.%d:1234", clients)
+		if setup != nil {
+			setup(req)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+	bearer := func(token string) func(r *http.Request) {
+		return func(r *http.Request) {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+	}
+	basic := func(name, password string) func(r *http.Request) {
+		return func(r *http.Request) {
+			r.SetBasicAuth(name, password)
+		}
+	}
+	// The stream returns after the first event
+	gone := func(r *http.Request) {
+		ctx, cancel := context.WithCancel(r.Context())
+		cancel()
+		*r = *r.WithContext(ctx)
+	}
+
+	rec := do("/api/v1/status", nil)
+	if rec.Code != http.StatusUnauthorized || len(rec.Header().Values("WWW-Authenticate")) != 2 {
+		t.Errorf("expect 401 with challenges, got %d %v", rec.Code, rec.Header())
+	}
+	cases := []struct {
+		target string
+		setup  func(r *http.Request)
+		code   int
+	}{
+		{"/", nil, http.StatusFound},
+		{"/ui/", nil, http.StatusOK},
+		{"/api/v1/status", bearer("0123456789abcdef"), http.StatusOK},
+		{"/api/v1/status", bearer("0123456789abcdeg"), http.StatusUnauthorized},
+		{"/api/v1/status", basic("admin", "pass"), http.StatusOK},
+		{"/api/v1/status", basic("admin", "wrong"), http.StatusUnauthorized},
+		{"/admin", bearer("0123456789abcdef"), http.StatusForbidden},
+		{"/admin", basic("admin", "pass"), http.StatusNoContent},
+		{"/api/v1/stream?token=0123456789abcdef", gone, http.StatusOK},
+		{"/api/v1/stream?token=0123456789abcdeg", gone, http.StatusUnauthorized},
+		{"/api/v1/status?token=0123456789abcdef", nil, http.StatusUnauthorized},
+	}
+	for _, c := range cases {
+		if rec := do(c.target, c.setup); rec.Code != c.code {
+			t.Errorf("%s: expect %d, got %d", c.target, c.code, rec.Code)
+		}
+	}
+
+	// Only configured origins are allowed by CORS
+	for origin, allowed := range map[string]bool{"https://example.com": true, "https://evil.com": false} {
+		rec := do("/api/v1/status", func(r *http.Request) {
+			bearer("0123456789abcdef")(r)
+			r.Header.Set("Origin", origin)
+		})
+		if got := rec.Header().Get("Access-Control-Allow-Origin") == origin; got != allowed {
+			t.Errorf("%s: expect allowed %v, got %v", origin, allowed, got)
+		}
+	}
+
+	// Nobody is admin without auth
+	m = _newTestMonitor(t, "no-auth")
+	e = newWeb(m)
+	e.GET("/admin", func(c echo.Context) error {
+		return c.NoContent(http.StatusNoContent)
+	}, web.RequireScope(model.AuthScopeAdmin))
+	if rec := do("/api/v1/status", nil); rec.Code != http.StatusOK {
+		t.Errorf("expect 200 without auth, got %d", rec.Code)
+	}
+	if rec := do("/admin", nil); rec.Code != http.StatusForbidden {
+		t.Errorf("expect 403 without auth, got %d", rec.Code)
+	}
+}
+
+func TestClientCANeedsTLS(t *testing.T) {
+	err := runWeb(newWeb(_newTestMonitor(t, "mtls")), &model.WebConfig{Addr: "127.0.0.1:0", ClientCA: "ca.pem"})
+	if err != ErrClientCANeedsTLS {
+		t.Errorf("expect ErrClientCANeedsTLS, got %v", err)
+	}
+}
+
+func TestAdminAPI(t *testing.T) {
+	config := _newTestConfig("admin")
+	config.Auth = &model.AuthConfig{
+		Tokens: []model.AuthToken{
+			{Token: "0123456789abcdef", Scope: model.AuthScopeAdmin},
+			{Token: "fedcba9876543210"},
+		},
+	}
+	m, err := New(config, testHostFS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.ConfigPath = filepath.Join(t.TempDir(), "config.json")
+	e := newWeb(m)
+
+	clients := 0
+	do := func(method, target, body, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		clients++
+		req.RemoteAddr = fmt.Sprintf("10.0.1.%d:1234", clients)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+	const admin = "0123456789abcdef"
+	rule := `{"type": "cpu", "threshold": ">=80%", "matcher": "cpu"}`
+	ruleId := url.PathEscape("Rule(cpu >=80% cpu)")
+	cases := []struct {
+		method, target, body string
+		code                 int
+	}{
+		{http.MethodPost, "/api/v1/rules", rule, http.StatusOK},
+		{http.MethodPost, "/api/v1/rules", rule, http.StatusConflict},
+		{http.MethodPost, "/api/v1/rules", `{"type": "cpu", "threshold": ">=80"}`, http.StatusBadRequest},
+		{http.MethodPost, "/api/v1/rules", `{"type": `, http.StatusBadRequest},
+		{http.MethodPut, "/api/v1/rules/" + ruleId, `{"type": "cpu", "threshold": ">=90%", "matcher": "cpu", "for": "1m"}`, http.StatusOK},
+		{http.MethodPut, "/api/v1/rules/" + ruleId, rule, http.StatusNotFound},
+		// Escaped in other ways than the default one
+		{http.MethodDelete, "/api/v1/rules/" + strings.ReplaceAll(url.PathEscape("Rule(mem >=1% used)"), "=", "%3D"), "", http.StatusOK},
+		{http.MethodPost, "/api/v1/pushes", `{"type": "webhook", "name": "hook", "iface": {"url": "http://localhost", "method": "POST"}}`, http.StatusOK},
+		{http.MethodPut, "/api/v1/pushes/hook", `{"type": "webhook", "name": "hook 2", "iface": {"url": "http://localhost", "method": "PUT"}}`, http.StatusBadRequest},
+		{http.MethodPut, "/api/v1/pushes/hook", `{"type": "webhook", "name": "hook 2", "iface": {"url": "http://localhost", "method": "GET"}}`, http.StatusOK},
+		{http.MethodDelete, "/api/v1/pushes/hook", "", http.StatusNotFound},
+		{http.MethodPut, "/api/v1/settings", `{"interval": "20s"}`, http.StatusBadRequest},
+		{http.MethodPut, "/api/v1/settings", `{"name": "renamed", "rate": "2/1m"}`, http.StatusOK},
+	}
+	for _, c := range cases {
+		if rec := do(c.method, c.target, c.body, admin); rec.Code != c.code {
+			t.Errorf("%s %s: expect %d, got %d: %s", c.method, c.target, c.code, rec.Code, rec.Body)
+		}
+	}
+	if rec := do(http.MethodDelete, "/api/v1/pushes/"+url.PathEscape("hook 2"), "", "fedcba9876543210"); rec.Code != http.StatusForbidden {
+		t.Errorf("expect 403 for read scope, got %d", rec.Code)
+	}
+
+	// Applied at once
+	config = m.Config()
+	if config.Name != "renamed" || config.Rate != "2/1m" || config.Interval != "1s" {
+		t.Errorf("unexpected settings: %+v", config)
+	}
+	if len(config.Rules) != 1 || config.Rules[0].Threshold != ">=90%" || config.Rules[0].For != "1m" {
+		t.Errorf("unexpected rules: %+v", config.Rules)
+	}
+	if len(config.Pushes) != 1 || config.Pushes[0].Name != "hook 2" {
+		t.Errorf("unexpected pushes: %+v", config.Pushes)
+	}
+	// Same as the saved one
+	saved, err := model.LoadAppConfig(m.ConfigPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Name != config.Name || len(saved.Rules) != 1 || saved.Rules[0] != config.Rules[0] ||
+		len(saved.Pushes) != 1 || saved.Pushes[0].Name != "hook 2" || !saved.Auth.Enabled() {
+		t.Errorf("saved config differs: %+v", saved)
+	}
+	if matches, _ := filepath.Glob(m.ConfigPath + ".*"); len(matches) != 0 {
+		t.Errorf("temp files left: %v", matches)
+	}
+}
@@ -0,0 +1,263 @@
+package tsdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Segments are append-only files of one UTC day, eg: "raw/20231018.seg".
+// Each record is:
+//
+//	uint16 length of key | key | int64 unix nano | float64 * values
+//
+// in little endian and in the order of time,
+// raw records have 1 value (the value),
+// rollup records have 4 values (avg, min, max, count).
+const (
+	segmentExt    = ".seg"
+	segmentLayout = "20060102"
+	maxKeyLen     = math.MaxUint16
+)
+
+type kind struct {
+	dir    string
+	values int
+}
+
+var (
+	kindRaw    = kind{dir: "raw", values: 1}
+	kindRollup = kind{dir: "1m", values: 4}
+)
+
+var errTornRecord = errors.New("torn record")
+
+func segmentName(t time.Time) string {
+	return t.UTC().Format(segmentLayout) + segmentExt
+}
+
+// segmentDay returns the start of the day of the segment file name.
+func segmentDay(name string) (time.Time, bool) {
+	if !strings.HasSuffix(name, segmentExt) {
+		return time.Time{}, false
+	}
+	day, err := time.Parse(segmentLayout, strings.TrimSuffix(name, segmentExt))
+	if err != nil {
+		return time.Time{}, false
+	}
+	return day, true
+}
+
+// segments returns the segment files in dir sorted by day.
+func segments(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	names := []string{}
+	for _, entry := range entries {
+		if _, ok := segmentDay(entry.Name()); ok && !entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func encodeRecord(buf []byte, key string, t time.Time, values []float64) []byte {
+	buf = binary.LittleEndian.AppendUint16(buf, uint16(len(key)))
+	buf = append(buf, key...)
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(t.UnixNano()))
+	for _, v := range values {
+		buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(v))
+	}
+	return buf
+}
+
+// segmentIndex is the offset of the first record of each minute in a segment,
+// so reading from a time doesn't start at the beginning of the segment.
+type segmentIndex struct {
+	// Unix nano of the start of minutes
+	minutes []int64
+	offsets []int64
+}
+
+// mark is the offset of a record in a buffer to write.
+type mark struct {
+	t   time.Time
+	off int64
+}
+
+func (ix *segmentIndex) add(t time.Time, off int64) {
+	m := t.Truncate(RollupInterval).UnixNano()
+	if n := len(ix.minutes); n > 0 && ix.minutes[n-1] >= m {
+		return
+	}
+	ix.minutes = append(ix.minutes, m)
+	ix.offsets = append(ix.offsets, off)
+}
+
+// seek returns the offset to read the records at or after from.
+func (ix *segmentIndex) seek(from time.Time) int64 {
+	m := from.Truncate(RollupInterval).UnixNano()
+	i := sort.Search(len(ix.minutes), func(i int) bool { return ix.minutes[i] > m })
+	if i == 0 {
+		return 0
+	}
+	return ix.offsets[i-1]
+}
+
+// readSegment calls fn with the records of key in path from offset, or all records
+// if key is empty, and returns the end of the valid records read.
+// Keys and values of the other keys are skipped without reading them.
+// Records are in the order of time, so it stops at the first one after to
+// unless to is zero. The records read are added to ix if it's not nil.
+func readSegment(path string, k kind, key string, offset int64, to time.Time, ix *segmentIndex, fn func(key string, t time.Time, values []float64)) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	r := bufio.NewReader(f)
+	valid := offset
+	header := make([]byte, 2)
+	keyBuf := make([]byte, 0, 64)
+	timeBuf := make([]byte, 8)
+	values := make([]float64, k.values)
+	valuesLen := 8 * k.values
+	raw := make([]byte, valuesLen)
+	for {
+		_, err := io.ReadFull(r, header)
+		if err == io.EOF {
+			return valid, nil
+		}
+		if err != nil {
+			return valid, errTornRecord
+		}
+		keyLen := int(binary.LittleEndian.Uint16(header))
+		// Only the keys of the same length are compared
+		match := key == "" || keyLen == len(key)
+		if match {
+			if cap(keyBuf) < keyLen {
+				keyBuf = make([]byte, keyLen)
+			}
+			keyBuf = keyBuf[:keyLen]
+			_, err = io.ReadFull(r, keyBuf)
+			match = key == "" || string(keyBuf) == key
+		} else {
+			_, err = r.Discard(keyLen)
+		}
+		if err != nil {
+			return valid, errTornRecord
+		}
+		if _, err := io.ReadFull(r, timeBuf); err != nil {
+			return valid, errTornRecord
+		}
+		t := time.Unix(0, int64(binary.LittleEndian.Uint64(timeBuf)))
+		if !to.IsZero() && t.After(to) {
+			return valid, nil
+		}
+		if !match {
+			_, err = r.Discard(valuesLen)
+		} else {
+			_, err = io.ReadFull(r, raw)
+		}
+		if err != nil {
+			return valid, errTornRecord
+		}
+		if ix != nil {
+			ix.add(t, valid)
+		}
+		valid += int64(2 + keyLen + 8 + valuesLen)
+		if !match {
+			continue
+		}
+		for i := range values {
+			values[i] = math.Float64frombits(binary.LittleEndian.Uint64(raw[8*i:]))
+		}
+		fn(string(keyBuf), t, values)
+	}
+}
+
+// segmentWriter appends to the segment of the current day.
+type segmentWriter struct {
+	dir  string
+	kind kind
+	name string
+	f    *os.File
+	// Size and index of the segment being written
+	size  int64
+	index *segmentIndex
+}
+
+// write appends buf to the segment of day,
+// marks are the offsets of the records in buf to index.
+func (w *segmentWriter) write(day time.Time, buf []byte, marks []mark) error {
+	name := segmentName(day)
+	if w.f == nil || w.name != name {
+		if err := w.open(name); err != nil {
+			return err
+		}
+	}
+	n, err := w.f.Write(buf)
+	if err == nil {
+		for _, m := range marks {
+			w.index.add(m.t, w.size+m.off)
+		}
+	}
+	w.size += int64(n)
+	return err
+}
+
+func (w *segmentWriter) open(name string) error {
+	w.close()
+	dir := filepath.Join(w.dir, w.kind.dir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	path := filepath.Join(dir, name)
+	index := &segmentIndex{}
+	var size int64
+	// Drop the torn record at the end after a crash,
+	// or the records appended after it can't be read.
+	if _, err := os.Stat(path); err == nil {
+		valid, err := readSegment(path, w.kind, "", 0, time.Time{}, index, func(string, time.Time, []float64) {})
+		if err == errTornRecord {
+			if err := os.Truncate(path, valid); err != nil {
+				return err
+			}
+		} else if err != nil {
+			return err
+		}
+		size = valid
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	w.f, w.name, w.size, w.index = f, name, size, index
+	return nil
+}
+
+func (w *segmentWriter) close() error {
+	if w.f == nil {
+		return nil
+	}
+	err := w.f.Close()
+	w.f, w.name, w.size, w.index = nil, "", 0, nil
+	return err
+}
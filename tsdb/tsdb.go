@@ -0,0 +1,345 @@
+// Package tsdb stores metrics on disk in append-only segment files.
+// Raw points are kept for Retention.Raw, and rolled up into
+// one point per minute which are kept for Retention.Rollup.
+package tsdb
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/lollipopkit/gommon/log"
+)
+
+var (
+	ErrClosed      = errors.New("tsdb is closed")
+	ErrKeyTooLong  = errors.New("key is too long")
+	ErrInvalidTime = errors.New("invalid time range")
+)
+
+const RollupInterval = time.Minute
+
+type Retention struct {
+	Raw    time.Duration
+	Rollup time.Duration
+}
+
+// Point is a value of a metric at a time.
+// Min and Max are the same as Value for raw points.
+type Point struct {
+	Key   string
+	Time  time.Time
+	Value float64
+	Min   float64
+	Max   float64
+	// Number of the raw points rolled up, 1 for raw points
+	Count int
+}
+
+type DB struct {
+	dir       string
+	retention Retention
+
+	raw    *segmentWriter
+	rollup *segmentWriter
+	// The minute being rolled up
+	minute time.Time
+	// Key -> the rollup of minute
+	buckets map[string]*bucket
+	// Key -> the last minute rolled up before opening,
+	// which is not rolled up again after a restart
+	flushed map[string]time.Time
+	// Path -> index of the segments not being written
+	indexes map[string]*segmentIndex
+	// Day of the last compaction
+	compacted time.Time
+	closed    bool
+	lock      sync.Mutex
+}
+
+type bucket struct {
+	sum, min, max float64
+	count         int
+}
+
+func (b *bucket) add(v float64) {
+	b.sum += v
+	b.count++
+	b.min = math.Min(b.min, v)
+	b.max = math.Max(b.max, v)
+}
+
+// Open opens the store in dir, it's created if not exist.
+// Segments out of retention are removed.
+func Open(dir string, retention Retention) (*DB, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	db := &DB{
+		dir:       dir,
+		retention: retention,
+		raw:       &segmentWriter{dir: dir, kind: kindRaw},
+		rollup:    &segmentWriter{dir: dir, kind: kindRollup},
+		buckets:   map[string]*bucket{},
+		flushed:   map[string]time.Time{},
+		indexes:   map[string]*segmentIndex{},
+	}
+	db.compact(time.Now())
+	if err := db.loadFlushed(); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// loadFlushed reads the last minute rolled up of each key from the last rollup segment.
+func (db *DB) loadFlushed() error {
+	dir := filepath.Join(db.dir, kindRollup.dir)
+	names, err := segments(dir)
+	if err != nil || len(names) == 0 {
+		return err
+	}
+	_, err = readSegment(filepath.Join(dir, names[len(names)-1]), kindRollup, "", 0, time.Time{}, nil, func(key string, t time.Time, _ []float64) {
+		db.flushed[key] = t
+	})
+	if err != nil && err != errTornRecord {
+		return err
+	}
+	return nil
+}
+
+// SetRetention applies retention from the next compaction.
+func (db *DB) SetRetention(retention Retention) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	db.retention = retention
+	db.compacted = time.Time{}
+}
+
+// Append writes points, they should be later than the appended ones.
+func (db *DB) Append(points ...Point) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	if db.closed {
+		return ErrClosed
+	}
+	if len(points) == 0 {
+		return nil
+	}
+
+	var buf []byte
+	var marks []mark
+	day := points[0].Time
+	for _, p := range points {
+		if len(p.Key) > maxKeyLen {
+			return fmt.Errorf("%w: %s", ErrKeyTooLong, p.Key[:32])
+		}
+		if segmentName(p.Time) != segmentName(day) {
+			if err := db.raw.write(day, buf, marks); err != nil {
+				return err
+			}
+			buf, marks, day = buf[:0], marks[:0], p.Time
+		}
+		if n := len(marks); n == 0 || !marks[n-1].t.Truncate(RollupInterval).Equal(p.Time.Truncate(RollupInterval)) {
+			marks = append(marks, mark{t: p.Time, off: int64(len(buf))})
+		}
+		buf = encodeRecord(buf, p.Key, p.Time, []float64{p.Value})
+		if err := db.rollupPoint(p); err != nil {
+			return err
+		}
+	}
+	if err := db.raw.write(day, buf, marks); err != nil {
+		return err
+	}
+
+	now := points[len(points)-1].Time
+	if db.compacted.IsZero() || segmentName(db.compacted) != segmentName(now) {
+		db.compact(now)
+	}
+	return nil
+}
+
+func (db *DB) rollupPoint(p Point) error {
+	start := p.Time.Truncate(RollupInterval)
+	// Points are appended in order, so the minute before is complete
+	if start.After(db.minute) {
+		if err := db.flush(); err != nil {
+			return err
+		}
+		db.minute = start
+	}
+	b, ok := db.buckets[p.Key]
+	if !ok {
+		b = &bucket{min: math.Inf(1), max: math.Inf(-1)}
+		db.buckets[p.Key] = b
+	}
+	b.add(p.Value)
+	return nil
+}
+
+// flush writes the rollups of the minute, all keys at once
+// to keep the rollup records in the order of time.
+func (db *DB) flush() error {
+	if len(db.buckets) == 0 {
+		return nil
+	}
+	buckets := db.buckets
+	db.buckets = map[string]*bucket{}
+	keys := make([]string, 0, len(buckets))
+	for key := range buckets {
+		// Rolled up before a restart
+		if flushed, ok := db.flushed[key]; ok && !db.minute.After(flushed) {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	sort.Strings(keys)
+	var buf []byte
+	for _, key := range keys {
+		b := buckets[key]
+		buf = encodeRecord(buf, key, db.minute, []float64{b.sum / float64(b.count), b.min, b.max, float64(b.count)})
+	}
+	return db.rollup.write(db.minute, buf, []mark{{t: db.minute}})
+}
+
+// compact removes the segments out of retention.
+func (db *DB) compact(now time.Time) {
+	db.compacted = now
+	for _, k := range []struct {
+		kind
+		keep time.Duration
+	}{{kindRaw, db.retention.Raw}, {kindRollup, db.retention.Rollup}} {
+		if k.keep <= 0 {
+			continue
+		}
+		dir := filepath.Join(db.dir, k.dir)
+		names, err := segments(dir)
+		if err != nil {
+			log.Warn("[HISTORY] list %s failed: %v", dir, err)
+			continue
+		}
+		for _, name := range names {
+			day, _ := segmentDay(name)
+			// The whole day is out of retention
+			if now.Sub(day.Add(24*time.Hour)) <= k.keep {
+				continue
+			}
+			path := filepath.Join(dir, name)
+			delete(db.indexes, path)
+			if err := os.Remove(path); err != nil {
+				log.Warn("[HISTORY] remove %s failed: %v", name, err)
+			}
+		}
+	}
+}
+
+// Query returns the points of key in [from, to].
+// Raw points are used if from is in Retention.Raw, otherwise rollups.
+func (db *DB) Query(key string, from, to time.Time) ([]Point, error) {
+	if to.Before(from) {
+		return nil, ErrInvalidTime
+	}
+	db.lock.Lock()
+	useRaw := db.retention.Raw <= 0 || time.Since(from) <= db.retention.Raw
+	db.lock.Unlock()
+
+	points := []Point{}
+	k := kindRollup
+	if useRaw {
+		k = kindRaw
+	}
+	err := db.scan(k, key, from, to, func(p Point) {
+		points = append(points, p)
+	})
+	return points, err
+}
+
+// Scan calls fn with the raw points of all keys in [from, to],
+// in the order they were appended.
+func (db *DB) Scan(from, to time.Time, fn func(Point)) error {
+	if to.Before(from) {
+		return ErrInvalidTime
+	}
+	return db.scan(kindRaw, "", from, to, fn)
+}
+
+// scan calls fn with the points of key in [from, to], or all keys if key is empty.
+func (db *DB) scan(k kind, key string, from, to time.Time, fn func(Point)) error {
+	dir := filepath.Join(db.dir, k.dir)
+	names, err := segments(dir)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		day, _ := segmentDay(name)
+		if day.After(to) || day.Add(24*time.Hour).Before(from) {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		offset, err := db.seek(k, path, name, from)
+		if err != nil {
+			return err
+		}
+		_, err = readSegment(path, k, key, offset, to, nil, func(key string, t time.Time, values []float64) {
+			if t.Before(from) {
+				return
+			}
+			p := Point{Key: key, Time: t, Value: values[0], Min: values[0], Max: values[0], Count: 1}
+			if k == kindRollup {
+				p.Min, p.Max, p.Count = values[1], values[2], int(values[3])
+			}
+			fn(p)
+		})
+		// The torn record is the last one, the others are fine
+		if err != nil && err != errTornRecord {
+			return err
+		}
+	}
+	return nil
+}
+
+// seek returns the offset of the segment to read from,
+// the index of a segment not being written is built at the first read.
+func (db *DB) seek(k kind, path, name string, from time.Time) (int64, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	w := db.raw
+	if k == kindRollup {
+		w = db.rollup
+	}
+	if w.f != nil && w.name == name {
+		// Indexed before the writer opened it, and appended since
+		delete(db.indexes, path)
+		return w.index.seek(from), nil
+	}
+	ix, ok := db.indexes[path]
+	if !ok {
+		ix = &segmentIndex{}
+		_, err := readSegment(path, k, "", 0, time.Time{}, ix, func(string, time.Time, []float64) {})
+		if err != nil && err != errTornRecord {
+			return 0, err
+		}
+		db.indexes[path] = ix
+	}
+	return ix.seek(from), nil
+}
+
+// Close flushes the minutes being rolled up and closes the segments.
+func (db *DB) Close() error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	if db.closed {
+		return nil
+	}
+	db.closed = true
+	err := db.flush()
+	db.buckets = nil
+	return errors.Join(err, db.raw.close(), db.rollup.close())
+}
@@ -0,0 +1,224 @@
+package tsdb
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var testRetention = Retention{Raw: 24 * time.Hour, Rollup: 30 * 24 * time.Hour}
+
+func TestAppendQuery(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir, testRetention)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now().Add(-time.Hour).Truncate(time.Minute)
+	// 2 points per minute in 3 minutes
+	for i := 0; i < 6; i++ {
+		at := start.Add(time.Duration(i) * 30 * time.Second)
+		err := db.Append(
+			Point{Key: "cpu/cpu/percent", Time: at, Value: float64(i)},
+			Point{Key: "mem/used/size", Time: at, Value: 1},
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Survives reopening
+	db, err = Open(dir, testRetention)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	points, err := db.Query("cpu/cpu/percent", start, start.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 6 || points[5].Value != 5 || !points[5].Time.Equal(start.Add(150*time.Second)) {
+		t.Fatalf("unexpected raw points: %+v", points)
+	}
+
+	// Stops at to
+	points, err = db.Query("cpu/cpu/percent", start, start.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 3 || points[2].Value != 2 {
+		t.Fatalf("expect the points until to, got %+v", points)
+	}
+
+	rollups := []Point{}
+	err = db.scan(kindRollup, "cpu/cpu/percent", start, start.Add(time.Hour), func(p Point) {
+		rollups = append(rollups, p)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rollups) != 3 {
+		t.Fatalf("expect 3 rollups, got %+v", rollups)
+	}
+	if r := rollups[1]; r.Value != 2.5 || r.Min != 2 || r.Max != 3 || r.Count != 2 || !r.Time.Equal(start.Add(time.Minute)) {
+		t.Errorf("unexpected rollup: %+v", r)
+	}
+}
+
+func TestTornRecord(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir, testRetention)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	if err := db.Append(Point{Key: "k", Time: now, Value: 1}); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	// Crashed in the middle of writing
+	path := filepath.Join(dir, kindRaw.dir, segmentName(now))
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(encodeRecord(nil, "k", now, []float64{2})[:5])
+	f.Close()
+
+	db, err = Open(dir, testRetention)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.Append(Point{Key: "k", Time: now.Add(time.Millisecond), Value: 3}); err != nil {
+		t.Fatal(err)
+	}
+	points, err := db.Query("k", now.Add(-time.Second), now.Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 2 || points[0].Value != 1 || points[1].Value != 3 {
+		t.Errorf("expect [1 3], got %+v", points)
+	}
+}
+
+func TestCompact(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	old := now.Add(-3 * 24 * time.Hour)
+	for _, k := range []kind{kindRaw, kindRollup} {
+		if err := os.MkdirAll(filepath.Join(dir, k.dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, k.dir, segmentName(old)), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db, err := Open(dir, testRetention)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := os.Stat(filepath.Join(dir, kindRaw.dir, segmentName(old))); !os.IsNotExist(err) {
+		t.Error("raw segment out of retention should be removed")
+	}
+	if _, err := os.Stat(filepath.Join(dir, kindRollup.dir, segmentName(old))); err != nil {
+		t.Errorf("rollup segment in retention should be kept: %v", err)
+	}
+}
+
+func TestReopenInMinute(t *testing.T) {
+	dir := t.TempDir()
+	minute := time.Now().Add(-time.Hour).Truncate(time.Minute)
+	for i := 0; i < 2; i++ {
+		db, err := Open(dir, testRetention)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Restarted in the middle of the minute
+		if err := db.Append(Point{Key: "k", Time: minute.Add(time.Duration(i) * 20 * time.Second), Value: 1}); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db, err := Open(dir, testRetention)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.Append(Point{Key: "k", Time: minute.Add(time.Minute), Value: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	rollups := []Point{}
+	err = db.scan(kindRollup, "k", minute, minute.Add(time.Hour), func(p Point) {
+		rollups = append(rollups, p)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rollups) != 2 || !rollups[0].Time.Equal(minute) || !rollups[1].Time.Equal(minute.Add(time.Minute)) {
+		t.Errorf("expect one rollup per minute, got %+v", rollups)
+	}
+}
+
+func TestSegmentIndex(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir, testRetention)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	start := time.Now().Add(-time.Hour).Truncate(time.Hour)
+	for i := 0; i < 10; i++ {
+		at := start.Add(time.Duration(i) * 30 * time.Second)
+		if err := db.Append(Point{Key: "a", Time: at, Value: float64(i)}, Point{Key: "bb", Time: at, Value: -1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ix := db.raw.index
+	if len(ix.minutes) != 5 {
+		t.Fatalf("expect 5 minutes indexed, got %+v", ix)
+	}
+	if off := ix.seek(start.Add(90 * time.Second)); off != ix.offsets[1] {
+		t.Errorf("expect the offset of the second minute, got %d", off)
+	}
+	if off := ix.seek(start.Add(-time.Hour)); off != 0 {
+		t.Errorf("expect 0 before the first minute, got %d", off)
+	}
+
+	// The index built by reading is the same as the one built by writing
+	path := filepath.Join(dir, kindRaw.dir, segmentName(start))
+	read := &segmentIndex{}
+	if _, err := readSegment(path, kindRaw, "", 0, time.Time{}, read, func(string, time.Time, []float64) {}); err != nil {
+		t.Fatal(err)
+	}
+	if len(read.offsets) != len(ix.offsets) {
+		t.Fatalf("expect %+v, got %+v", ix, read)
+	}
+	for i := range ix.offsets {
+		if read.minutes[i] != ix.minutes[i] || read.offsets[i] != ix.offsets[i] {
+			t.Fatalf("expect %+v, got %+v", ix, read)
+		}
+	}
+
+	points, err := db.Query("a", start.Add(150*time.Second), start.Add(200*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 2 || points[0].Value != 5 || points[1].Value != 6 {
+		t.Errorf("expect [5 6], got %+v", points)
+	}
+}
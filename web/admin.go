@@ -0,0 +1,269 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lollipopkit/server_box_monitor/model"
+)
+
+var (
+	errNotFound = errors.New("not found")
+	errConflict = errors.New("conflict")
+)
+
+// ConfigEditor is where the admin handlers write to, such as *runner.Monitor.
+type ConfigEditor interface {
+	ConfigSource
+	// UpdateConfig applies fn to a copy of the config in use,
+	// validates it, saves it and applies it.
+	// The error is model.ConfigErrors if it's invalid.
+	UpdateConfig(fn func(config *model.AppConfig) error) (*model.AppConfig, error)
+}
+
+// Empty fields are not changed by UpdateSettings.
+type settingsResp struct {
+	Name     string `json:"name"`
+	Interval string `json:"interval"`
+	Rate     string `json:"rate"`
+}
+
+type configErrorResp struct {
+	Path string `json:"path"`
+	Msg  string `json:"msg"`
+}
+
+// CreateRule handles `POST /api/v1/rules`, the body is a model.Rule.
+func CreateRule(src ConfigEditor) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		rule := new(model.Rule)
+		if err := c.Bind(rule); err != nil {
+			return invalidParam(c, "invalid rule: "+err.Error())
+		}
+		return update(c, src, func(config *model.AppConfig) error {
+			if findRule(config, rule.Id()) != -1 {
+				return errors.Join(errConflict, fmt.Errorf("rule exists: %s", rule.Id()))
+			}
+			config.Rules = append(config.Rules, *rule)
+			return nil
+		}, func() any {
+			return ruleResp{Id: rule.Id(), Rule: *rule, State: model.AlertStateInactive.String()}
+		})
+	}
+}
+
+// UpdateRule handles `PUT /api/v1/rules/:id`,
+// id is the escaped Rule.Id() and the body is the new model.Rule.
+func UpdateRule(src ConfigEditor) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		id, err := pathParam(c, "id")
+		if err != nil {
+			return invalidParam(c, "invalid id: "+err.Error())
+		}
+		rule := new(model.Rule)
+		if err := c.Bind(rule); err != nil {
+			return invalidParam(c, "invalid rule: "+err.Error())
+		}
+		return update(c, src, func(config *model.AppConfig) error {
+			idx := findRule(config, id)
+			if idx == -1 {
+				return errors.Join(errNotFound, fmt.Errorf("rule not found: %s", id))
+			}
+			if other := findRule(config, rule.Id()); other != -1 && other != idx {
+				return errors.Join(errConflict, fmt.Errorf("rule exists: %s", rule.Id()))
+			}
+			config.Rules[idx] = *rule
+			return nil
+		}, func() any {
+			return ruleResp{Id: rule.Id(), Rule: *rule, State: model.AlertStateInactive.String()}
+		})
+	}
+}
+
+// DeleteRule handles `DELETE /api/v1/rules/:id`.
+func DeleteRule(src ConfigEditor) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		id, err := pathParam(c, "id")
+		if err != nil {
+			return invalidParam(c, "invalid id: "+err.Error())
+		}
+		return update(c, src, func(config *model.AppConfig) error {
+			idx := findRule(config, id)
+			if idx == -1 {
+				return errors.Join(errNotFound, fmt.Errorf("rule not found: %s", id))
+			}
+			config.Rules = append(config.Rules[:idx], config.Rules[idx+1:]...)
+			return nil
+		}, nil)
+	}
+}
+
+// CreatePush handles `POST /api/v1/pushes`, the body is a model.Push.
+func CreatePush(src ConfigEditor) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		push := new(model.Push)
+		if err := c.Bind(push); err != nil {
+			return invalidParam(c, "invalid push: "+err.Error())
+		}
+		return update(c, src, func(config *model.AppConfig) error {
+			if findPush(config, push.Name) != -1 {
+				return errors.Join(errConflict, fmt.Errorf("push exists: %s", push.Name))
+			}
+			config.Pushes = append(config.Pushes, *push)
+			return nil
+		}, func() any {
+			return pushResp{Name: push.Name, Type: push.Type, Resolved: push.Resolved}
+		})
+	}
+}
+
+// UpdatePush handles `PUT /api/v1/pushes/:name`, the body is the new model.Push.
+func UpdatePush(src ConfigEditor) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		name, err := pathParam(c, "name")
+		if err != nil {
+			return invalidParam(c, "invalid name: "+err.Error())
+		}
+		push := new(model.Push)
+		if err := c.Bind(push); err != nil {
+			return invalidParam(c, "invalid push: "+err.Error())
+		}
+		return update(c, src, func(config *model.AppConfig) error {
+			idx := findPush(config, name)
+			if idx == -1 {
+				return errors.Join(errNotFound, fmt.Errorf("push not found: %s", name))
+			}
+			// Duplicate names are found by validating
+			config.Pushes[idx] = *push
+			return nil
+		}, func() any {
+			return pushResp{Name: push.Name, Type: push.Type, Resolved: push.Resolved}
+		})
+	}
+}
+
+// DeletePush handles `DELETE /api/v1/pushes/:name`.
+func DeletePush(src ConfigEditor) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		name, err := pathParam(c, "name")
+		if err != nil {
+			return invalidParam(c, "invalid name: "+err.Error())
+		}
+		return update(c, src, func(config *model.AppConfig) error {
+			idx := findPush(config, name)
+			if idx == -1 {
+				return errors.Join(errNotFound, fmt.Errorf("push not found: %s", name))
+			}
+			config.Pushes = append(config.Pushes[:idx], config.Pushes[idx+1:]...)
+			return nil
+		}, nil)
+	}
+}
+
+// Settings handles `GET /api/v1/settings`.
+func Settings(src ConfigSource) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		config := src.Config()
+		return ok(c, settingsResp{
+			Name:     config.Name,
+			Interval: config.Interval,
+			Rate:     config.Rate,
+		})
+	}
+}
+
+// UpdateSettings handles `PUT /api/v1/settings`,
+// only the fields in the body are changed.
+func UpdateSettings(src ConfigEditor) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var body struct {
+			Name     *string `json:"name"`
+			Interval *string `json:"interval"`
+			Rate     *string `json:"rate"`
+		}
+		if err := c.Bind(&body); err != nil {
+			return invalidParam(c, "invalid settings: "+err.Error())
+		}
+		var updated *model.AppConfig
+		return update(c, src, func(config *model.AppConfig) error {
+			if body.Name != nil {
+				config.Name = *body.Name
+			}
+			if body.Interval != nil {
+				config.Interval = *body.Interval
+			}
+			if body.Rate != nil {
+				config.Rate = *body.Rate
+			}
+			updated = config
+			return nil
+		}, func() any {
+			return settingsResp{Name: updated.Name, Interval: updated.Interval, Rate: updated.Rate}
+		})
+	}
+}
+
+// update applies fn by src, and responds with resp() if it succeeds.
+func update(c echo.Context, src ConfigEditor, fn func(config *model.AppConfig) error, resp func() any) error {
+	_, err := src.UpdateConfig(fn)
+	var diags model.ConfigErrors
+	switch {
+	case err == nil:
+		if resp == nil {
+			return ok(c, nil)
+		}
+		return ok(c, resp())
+	case errors.As(err, &diags):
+		errs := make([]configErrorResp, 0, len(diags))
+		for _, diag := range diags {
+			errs = append(errs, configErrorResp{Path: diag.Path, Msg: diag.Err.Error()})
+		}
+		return c.JSON(http.StatusBadRequest, map[string]any{
+			"code":   respCodeInvalidConfig,
+			"msg":    fmt.Sprintf("%d errors in config", len(diags)),
+			"errors": errs,
+		})
+	case errors.Is(err, errNotFound):
+		return c.JSON(http.StatusNotFound, map[string]any{
+			"code": respCodeNotFound,
+			"msg":  err.Error(),
+		})
+	case errors.Is(err, errConflict):
+		return c.JSON(http.StatusConflict, map[string]any{
+			"code": respCodeConflict,
+			"msg":  err.Error(),
+		})
+	}
+	return fail(c, int(respCodeFail), err.Error())
+}
+
+// pathParam returns the unescaped path param.
+// echo only gives the unescaped one if the path is escaped in the default way.
+func pathParam(c echo.Context, name string) (string, error) {
+	param := c.Param(name)
+	if c.Request().URL.RawPath == "" {
+		return param, nil
+	}
+	return url.PathUnescape(param)
+}
+
+func findRule(config *model.AppConfig, id string) int {
+	for i := range config.Rules {
+		if config.Rules[i].Id() == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func findPush(config *model.AppConfig, name string) int {
+	for i := range config.Pushes {
+		if config.Pushes[i].Name == name {
+			return i
+		}
+	}
+	return -1
+}
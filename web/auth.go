@@ -0,0 +1,110 @@
+package web
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/lollipopkit/gommon/util"
+	"github.com/lollipopkit/server_box_monitor/model"
+)
+
+// AuthSource is where the auth config is read from, such as *runner.Monitor.
+// It's read for every request, so reloading the config takes effect at once.
+type AuthSource interface {
+	Config() *model.AppConfig
+}
+
+// Key of the granted model.AuthScope in echo.Context
+const authScopeKey = "auth_scope"
+
+type AuthenticateConfig struct {
+	// Skips the public routes, eg: files of the UI which have no data in them
+	Skipper middleware.Skipper
+	// Routes which also accept the token in the `token` query,
+	// because EventSource of browsers can't set headers.
+	// It only grants model.AuthScopeRead, since URLs may be logged.
+	QueryTokenRoutes []string
+}
+
+// Authenticate checks the bearer token or basic auth of every request,
+// and grants the scope of it to the handlers after it.
+// If auth is not enabled, everyone is granted model.AuthScopeRead.
+func Authenticate(src AuthSource, config AuthenticateConfig) echo.MiddlewareFunc {
+	if config.Skipper == nil {
+		config.Skipper = middleware.DefaultSkipper
+	}
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if config.Skipper(c) {
+				return next(c)
+			}
+			auth := src.Config().Auth
+			if !auth.Enabled() {
+				c.Set(authScopeKey, model.AuthScopeRead)
+				return next(c)
+			}
+
+			var scope model.AuthScope
+			var ok bool
+			header := c.Request().Header.Get(echo.HeaderAuthorization)
+			if token, found := strings.CutPrefix(header, "Bearer "); found {
+				scope, ok = auth.ByToken(strings.TrimSpace(token))
+			} else if name, password, found := c.Request().BasicAuth(); found {
+				scope, ok = auth.ByUser(name, password)
+			} else if token := c.QueryParam("token"); token != "" && util.Contains(config.QueryTokenRoutes, c.Path()) {
+				_, ok = auth.ByToken(token)
+				scope = model.AuthScopeRead
+			}
+			if !ok {
+				return unauthorized(c, auth)
+			}
+			c.Set(authScopeKey, scope)
+			return next(c)
+		}
+	}
+}
+
+// RequireScope rejects the requests whose granted scope doesn't cover scope.
+// It should be used after Authenticate.
+func RequireScope(scope model.AuthScope) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			granted, _ := c.Get(authScopeKey).(model.AuthScope)
+			if !granted.Allows(scope) {
+				return c.JSON(http.StatusForbidden, map[string]any{
+					"code": respCodeForbidden,
+					"msg":  "scope " + string(scope) + " is required",
+				})
+			}
+			return next(c)
+		}
+	}
+}
+
+// AllowOrigin is used by the CORS middleware.
+// All origins are allowed if auth is not enabled.
+func AllowOrigin(src AuthSource) func(origin string) (bool, error) {
+	return func(origin string) (bool, error) {
+		auth := src.Config().Auth
+		if !auth.Enabled() {
+			return true, nil
+		}
+		return util.Contains(auth.Origins, origin), nil
+	}
+}
+
+func unauthorized(c echo.Context, auth *model.AuthConfig) error {
+	// Browsers ask for the password with it, eg: when opening the UI
+	if len(auth.Users) > 0 {
+		c.Response().Header().Add(echo.HeaderWWWAuthenticate, `Basic realm="ServerBox Monitor", charset="UTF-8"`)
+	}
+	if len(auth.Tokens) > 0 {
+		c.Response().Header().Add(echo.HeaderWWWAuthenticate, `Bearer realm="ServerBox Monitor"`)
+	}
+	return c.JSON(http.StatusUnauthorized, map[string]any{
+		"code": respCodeUnauthorized,
+		"msg":  "unauthorized",
+	})
+}
@@ -1,6 +1,10 @@
 package web
 
-import "github.com/labstack/echo/v4"
+import (
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
 
 func ok(c echo.Context, data any) error {
 	return c.JSON(200, map[string]any{
@@ -15,3 +19,18 @@ func fail(c echo.Context, code int, data any) error {
 		"msg":  data,
 	})
 }
+
+func invalidParam(c echo.Context, msg string) error {
+	return c.JSON(400, map[string]any{
+		"code": respCodeInvalidParam,
+		"msg":  msg,
+	})
+}
+
+// unixMilli returns 0 for the zero time.
+func unixMilli(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixMilli()
+}
@@ -5,4 +5,10 @@ type respCode uint8
 const (
 	respCodeOK respCode = iota
 	respCodeFail
+	respCodeInvalidParam
+	respCodeUnauthorized
+	respCodeForbidden
+	respCodeNotFound
+	respCodeConflict
+	respCodeInvalidConfig
 )
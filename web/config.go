@@ -0,0 +1,97 @@
+package web
+
+import (
+	"sort"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lollipopkit/server_box_monitor/model"
+)
+
+// ConfigSource is where the handlers of config read from, such as *runner.Monitor.
+type ConfigSource interface {
+	Config() *model.AppConfig
+	// Pending and firing alerts by Rule.Id()
+	Alerts() map[string]model.Alert
+}
+
+// Times are unix milliseconds, 0 if not happened.
+type alertResp struct {
+	Rule     string `json:"rule"`
+	State    string `json:"state"`
+	ActiveAt int64  `json:"active_at"`
+	FiredAt  int64  `json:"fired_at"`
+}
+
+type ruleResp struct {
+	Id string `json:"id"`
+	model.Rule
+	State    string `json:"state"`
+	ActiveAt int64  `json:"active_at"`
+	FiredAt  int64  `json:"fired_at"`
+}
+
+// Ifaces are not returned, they have tokens in them.
+type pushResp struct {
+	Name     string         `json:"name"`
+	Type     model.PushType `json:"type"`
+	Resolved bool           `json:"resolved"`
+}
+
+// Alerts handles `GET /api/v1/alerts`.
+func Alerts(src ConfigSource) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		alerts := src.Alerts()
+		resp := make([]alertResp, 0, len(alerts))
+		for id, alert := range alerts {
+			resp = append(resp, alertResp{
+				Rule:     id,
+				State:    alert.State.String(),
+				ActiveAt: unixMilli(alert.ActiveAt),
+				FiredAt:  unixMilli(alert.FiredAt),
+			})
+		}
+		sort.Slice(resp, func(i, j int) bool {
+			return resp[i].Rule < resp[j].Rule
+		})
+		return ok(c, resp)
+	}
+}
+
+// Rules handles `GET /api/v1/rules`, rules are along with their states.
+func Rules(src ConfigSource) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		rules := src.Config().Rules
+		alerts := src.Alerts()
+		resp := make([]ruleResp, 0, len(rules))
+		for _, rule := range rules {
+			alert, ok := alerts[rule.Id()]
+			if !ok {
+				alert.State = model.AlertStateInactive
+			}
+			resp = append(resp, ruleResp{
+				Id:       rule.Id(),
+				Rule:     rule,
+				State:    alert.State.String(),
+				ActiveAt: unixMilli(alert.ActiveAt),
+				FiredAt:  unixMilli(alert.FiredAt),
+			})
+		}
+		return ok(c, resp)
+	}
+}
+
+// Pushes handles `GET /api/v1/pushes`.
+func Pushes(src ConfigSource) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		pushes := src.Config().Pushes
+		resp := make([]pushResp, 0, len(pushes))
+		for _, push := range pushes {
+			resp = append(resp, pushResp{
+				Name:     push.Name,
+				Type:     push.Type,
+				Resolved: push.Resolved,
+			})
+		}
+		return ok(c, resp)
+	}
+}
@@ -0,0 +1,171 @@
+package web
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lollipopkit/gommon/util"
+	"github.com/lollipopkit/server_box_monitor/model"
+	"github.com/lollipopkit/server_box_monitor/res"
+	"github.com/lollipopkit/server_box_monitor/tsdb"
+)
+
+// HistorySource is where History reads from, such as *runner.Monitor.
+type HistorySource interface {
+	History(key string, from, to time.Time) ([]tsdb.Point, error)
+}
+
+type historyPoint struct {
+	// Unix milliseconds of the start of the step
+	Time  int64   `json:"time"`
+	Value float64 `json:"value"`
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+}
+
+type historyResp struct {
+	Metric  string         `json:"metric"`
+	Matcher string         `json:"matcher"`
+	Unit    string         `json:"unit"`
+	From    int64          `json:"from"`
+	To      int64          `json:"to"`
+	Step    string         `json:"step"`
+	Points  []historyPoint `json:"points"`
+}
+
+// History handles `GET /api/v1/history`:
+//
+//	metric: monitor type, eg: "cpu"
+//	matcher: same as Rule.Matcher, it's required except for "cpu" whose default is "cpu"
+//	unit: "percent" "size" "speed" "temperature", default is the first one metric supports
+//	from, to: unix seconds or RFC3339, default is the last hour
+//	step: such as "1m", values in one step are averaged.
+//	      It's raised if there are more than res.HistoryMaxPoints steps.
+func History(src HistorySource) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		return history(c, src)
+	}
+}
+
+func history(c echo.Context, src HistorySource) error {
+	metric := model.MonitorType(c.QueryParam("metric"))
+	collector := model.CollectorOf(metric)
+	if collector == nil {
+		return invalidParam(c, fmt.Sprintf("unknown metric: %s", metric))
+	}
+	matcher := c.QueryParam("matcher")
+	if matcher == "" {
+		// Other metrics have no value for all devices
+		if metric != model.MonitorTypeCPU {
+			return invalidParam(c, fmt.Sprintf("matcher is required by %s", metric))
+		}
+		matcher = string(metric)
+	}
+	if checker, ok := collector.(model.MatcherChecker); ok {
+		if err := checker.CheckMatcher(metric, matcher); err != nil {
+			return invalidParam(c, err.Error())
+		}
+	}
+	supported := collector.ThresholdTypes(metric)
+	unit := supported[0]
+	if name := c.QueryParam("unit"); name != "" {
+		unit = model.ThresholdTypeOf(name)
+		if !util.Contains(supported, unit) {
+			return invalidParam(c, fmt.Sprintf("unit %s is not supported by %s", name, metric))
+		}
+	}
+
+	to := time.Now()
+	if s := c.QueryParam("to"); s != "" {
+		t, err := parseTime(s)
+		if err != nil {
+			return invalidParam(c, "invalid to: "+err.Error())
+		}
+		to = t
+	}
+	from := to.Add(-time.Hour)
+	if s := c.QueryParam("from"); s != "" {
+		t, err := parseTime(s)
+		if err != nil {
+			return invalidParam(c, "invalid from: "+err.Error())
+		}
+		from = t
+	}
+	if !from.Before(to) {
+		return invalidParam(c, "from should be before to")
+	}
+
+	span := to.Sub(from)
+	step := span / 100
+	if s := c.QueryParam("step"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			return invalidParam(c, "invalid step: "+s)
+		}
+		step = d
+	}
+	if minStep := span / res.HistoryMaxPoints; step < minStep {
+		step = minStep
+	}
+	if step < time.Second {
+		step = time.Second
+	}
+
+	points, err := src.History(model.MetricKey(metric, matcher, unit), from, to)
+	if err != nil {
+		return fail(c, int(respCodeFail), err.Error())
+	}
+	return ok(c, historyResp{
+		Metric:  string(metric),
+		Matcher: matcher,
+		Unit:    unit.Name(),
+		From:    from.Unix(),
+		To:      to.Unix(),
+		Step:    step.String(),
+		Points:  downsample(points, from, step),
+	})
+}
+
+// parseTime parses unix seconds or RFC3339
+func parseTime(s string) (time.Time, error) {
+	if sec, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// downsample averages points in every step from from,
+// weighted by the number of raw points in them.
+// Steps without points are skipped.
+func downsample(points []tsdb.Point, from time.Time, step time.Duration) []historyPoint {
+	result := []historyPoint{}
+	var cur *historyPoint
+	var count int
+	for _, p := range points {
+		start := from.Add(p.Time.Sub(from) / step * step)
+		if cur == nil || cur.Time != start.UnixMilli() {
+			if cur != nil {
+				cur.Value /= float64(count)
+				result = append(result, *cur)
+			}
+			cur = &historyPoint{Time: start.UnixMilli(), Min: math.Inf(1), Max: math.Inf(-1)}
+			count = 0
+		}
+		weight := p.Count
+		if weight < 1 {
+			weight = 1
+		}
+		cur.Value += p.Value * float64(weight)
+		cur.Min = math.Min(cur.Min, p.Min)
+		cur.Max = math.Max(cur.Max, p.Max)
+		count += weight
+	}
+	if cur != nil {
+		cur.Value /= float64(count)
+		result = append(result, *cur)
+	}
+	return result
+}
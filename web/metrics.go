@@ -0,0 +1,154 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Counters are the internal counters exposed in /metrics.
+type Counters struct {
+	// Push name -> count
+	PushSuccess map[string]uint64
+	PushFailure map[string]uint64
+	// Rule id -> count
+	RuleErrors map[string]uint64
+}
+
+// MetricsSource is where Metrics reads from, such as *runner.Monitor.
+type MetricsSource interface {
+	Source
+	Counters() Counters
+}
+
+// Metrics handles `GET /metrics` in the Prometheus text format.
+func Metrics(src MetricsSource) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		return metrics(c, src)
+	}
+}
+
+func metrics(c echo.Context, src MetricsSource) error {
+	w := new(metricsWriter)
+	s := src.Status()
+
+	w.family("server_box_info", "gauge", "Name of the server in the config.")
+	w.sample("server_box_info", 1, "name", src.Name())
+
+	w.family("server_box_cpu_usage_percent", "gauge", "CPU usage between the last two ticks.")
+	for i := range s.CPU {
+		percent, err := s.CPU[i].UsedPercent()
+		if err != nil {
+			continue
+		}
+		name := "cpu"
+		if i > 0 {
+			name = fmt.Sprintf("cpu%d", i-1)
+		}
+		w.sample("server_box_cpu_usage_percent", percent, "cpu", name)
+	}
+
+	w.family("server_box_memory_bytes", "gauge", "Memory in bytes.")
+	if s.Mem != nil {
+		w.sample("server_box_memory_bytes", float64(s.Mem.Total), "type", "total")
+		w.sample("server_box_memory_bytes", float64(s.Mem.Used), "type", "used")
+		w.sample("server_box_memory_bytes", float64(s.Mem.Free), "type", "free")
+		w.sample("server_box_memory_bytes", float64(s.Mem.Avail), "type", "avail")
+	}
+	w.family("server_box_swap_bytes", "gauge", "Swap in bytes.")
+	if s.Swap != nil {
+		w.sample("server_box_swap_bytes", float64(s.Swap.Total), "type", "total")
+		w.sample("server_box_swap_bytes", float64(s.Swap.Used), "type", "used")
+		w.sample("server_box_swap_bytes", float64(s.Swap.Free), "type", "free")
+		w.sample("server_box_swap_bytes", float64(s.Swap.Cached), "type", "cached")
+	}
+
+	w.family("server_box_disk_bytes", "gauge", "Disk space of mounts in bytes.")
+	for _, d := range s.Disk {
+		w.sample("server_box_disk_bytes", float64(d.Total), "mount", d.MountPath, "filesystem", d.Filesystem, "type", "total")
+		w.sample("server_box_disk_bytes", float64(d.Used), "mount", d.MountPath, "filesystem", d.Filesystem, "type", "used")
+		w.sample("server_box_disk_bytes", float64(d.Avail), "mount", d.MountPath, "filesystem", d.Filesystem, "type", "avail")
+	}
+
+	w.family("server_box_network_bytes_total", "counter", "Bytes received and transmitted by interfaces.")
+	for _, n := range s.Network {
+		w.sample("server_box_network_bytes_total", float64(n.Receive()), "interface", n.Interface, "direction", "rx")
+		w.sample("server_box_network_bytes_total", float64(n.Transmit()), "interface", n.Interface, "direction", "tx")
+	}
+	w.family("server_box_network_speed_bytes_per_second", "gauge", "Bytes per second between the last two ticks.")
+	for _, n := range s.Network {
+		if rx, err := n.ReceiveSpeed(); err == nil {
+			w.sample("server_box_network_speed_bytes_per_second", float64(rx), "interface", n.Interface, "direction", "rx")
+		}
+		if tx, err := n.TransmitSpeed(); err == nil {
+			w.sample("server_box_network_speed_bytes_per_second", float64(tx), "interface", n.Interface, "direction", "tx")
+		}
+	}
+
+	w.family("server_box_temperature_celsius", "gauge", "Temperature of thermal zones.")
+	for _, t := range s.Temperature {
+		// Several zones can have the same type
+		w.sample("server_box_temperature_celsius", t.Value, "zone", t.Zone, "type", t.Name)
+	}
+
+	counters := src.Counters()
+	w.family("server_box_push_total", "counter", "Pushes by result.")
+	for _, name := range sortedKeys(counters.PushSuccess) {
+		w.sample("server_box_push_total", float64(counters.PushSuccess[name]), "push", name, "result", "success")
+	}
+	for _, name := range sortedKeys(counters.PushFailure) {
+		w.sample("server_box_push_total", float64(counters.PushFailure[name]), "push", name, "result", "failure")
+	}
+	w.family("server_box_rule_errors_total", "counter", "Errors when evaluating rules.")
+	for _, id := range sortedKeys(counters.RuleErrors) {
+		w.sample("server_box_rule_errors_total", float64(counters.RuleErrors[id]), "rule", id)
+	}
+
+	return c.Blob(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", []byte(w.String()))
+}
+
+// metricsWriter writes the Prometheus text exposition format.
+type metricsWriter struct {
+	strings.Builder
+}
+
+func (w *metricsWriter) family(name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// sample writes one line, labels are pairs of name and value.
+func (w *metricsWriter) sample(name string, value float64, labels ...string) {
+	w.WriteString(name)
+	if len(labels) > 0 {
+		w.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", labels[i], escapeLabel(labels[i+1]))
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+	w.WriteByte('\n')
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func sortedKeys(m map[string]uint64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
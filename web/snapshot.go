@@ -0,0 +1,149 @@
+package web
+
+import (
+	"fmt"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lollipopkit/server_box_monitor/model"
+)
+
+// Sizes are in bytes, speeds are in bytes per second,
+// times are unix milliseconds.
+type snapshotResp struct {
+	Name string `json:"name"`
+	// When the last tick finished, 0 if there's no tick yet
+	Time int64 `json:"time"`
+	// False until every rate can be calculated, which needs two ticks
+	Ready       bool                  `json:"ready"`
+	CPU         []snapshotCPU         `json:"cpu"`
+	Mem         *snapshotMem          `json:"mem"`
+	Swap        *snapshotSwap         `json:"swap"`
+	Disk        []snapshotDisk        `json:"disk"`
+	Network     []snapshotNetwork     `json:"network"`
+	Temperature []snapshotTemperature `json:"temperature"`
+}
+
+type snapshotCPU struct {
+	// Same as Rule.Matcher, eg: "cpu" "cpu0"
+	Name    string  `json:"name"`
+	Percent float64 `json:"percent"`
+	Ready   bool    `json:"ready"`
+}
+
+type snapshotMem struct {
+	Total model.Size `json:"total"`
+	Avail model.Size `json:"avail"`
+	Free  model.Size `json:"free"`
+	Used  model.Size `json:"used"`
+}
+
+type snapshotSwap struct {
+	Total  model.Size `json:"total"`
+	Free   model.Size `json:"free"`
+	Used   model.Size `json:"used"`
+	Cached model.Size `json:"cached"`
+}
+
+type snapshotDisk struct {
+	Mount       string     `json:"mount"`
+	Filesystem  string     `json:"filesystem"`
+	Total       model.Size `json:"total"`
+	Used        model.Size `json:"used"`
+	Avail       model.Size `json:"avail"`
+	UsedPercent float64    `json:"used_percent"`
+}
+
+type snapshotNetwork struct {
+	Interface string     `json:"interface"`
+	Receive   model.Size `json:"rx"`
+	Transmit  model.Size `json:"tx"`
+	RxSpeed   model.Size `json:"rx_speed"`
+	TxSpeed   model.Size `json:"tx_speed"`
+	Ready     bool       `json:"ready"`
+}
+
+type snapshotTemperature struct {
+	Name  string  `json:"name"`
+	Value float64 `json:"value"`
+}
+
+// Snapshot handles `GET /api/v1/status`, it returns the last tick
+// with raw numbers. The legacy `/status` is kept for the ServerBox widget.
+func Snapshot(src Source) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		return ok(c, newSnapshot(src.Name(), src.Status()))
+	}
+}
+
+func newSnapshot(name string, s *model.ServerStatus) *snapshotResp {
+	resp := &snapshotResp{
+		Name:        name,
+		Ready:       !s.Time.IsZero(),
+		CPU:         make([]snapshotCPU, 0, len(s.CPU)),
+		Disk:        make([]snapshotDisk, 0, len(s.Disk)),
+		Network:     make([]snapshotNetwork, 0, len(s.Network)),
+		Temperature: make([]snapshotTemperature, 0, len(s.Temperature)),
+	}
+	resp.Time = unixMilli(s.Time)
+
+	for i := range s.CPU {
+		percent, err := s.CPU[i].UsedPercent()
+		name := "cpu"
+		if i > 0 {
+			name = fmt.Sprintf("cpu%d", i-1)
+		}
+		resp.CPU = append(resp.CPU, snapshotCPU{
+			Name:    name,
+			Percent: percent,
+			Ready:   err == nil,
+		})
+		resp.Ready = resp.Ready && err == nil
+	}
+	if s.Mem != nil {
+		resp.Mem = &snapshotMem{
+			Total: s.Mem.Total,
+			Avail: s.Mem.Avail,
+			Free:  s.Mem.Free,
+			Used:  s.Mem.Used,
+		}
+	}
+	if s.Swap != nil {
+		resp.Swap = &snapshotSwap{
+			Total:  s.Swap.Total,
+			Free:   s.Swap.Free,
+			Used:   s.Swap.Used,
+			Cached: s.Swap.Cached,
+		}
+	}
+	for _, d := range s.Disk {
+		resp.Disk = append(resp.Disk, snapshotDisk{
+			Mount:       d.MountPath,
+			Filesystem:  d.Filesystem,
+			Total:       d.Total,
+			Used:        d.Used,
+			Avail:       d.Avail,
+			UsedPercent: d.UsedPercent,
+		})
+	}
+	for _, n := range s.Network {
+		rx, rxErr := n.ReceiveSpeed()
+		tx, txErr := n.TransmitSpeed()
+		ready := rxErr == nil && txErr == nil
+		resp.Network = append(resp.Network, snapshotNetwork{
+			Interface: n.Interface,
+			Receive:   n.Receive(),
+			Transmit:  n.Transmit(),
+			RxSpeed:   rx,
+			TxSpeed:   tx,
+			Ready:     ready,
+		})
+		resp.Ready = resp.Ready && ready
+	}
+	for _, t := range s.Temperature {
+		resp.Temperature = append(resp.Temperature, snapshotTemperature{
+			Name:  t.Name,
+			Value: t.Value,
+		})
+	}
+	return resp
+}
@@ -0,0 +1,104 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lollipopkit/server_box_monitor/model"
+	"github.com/lollipopkit/server_box_monitor/res"
+)
+
+// Event is sent to stream clients, only one of the fields is set.
+type Event struct {
+	// A new tick
+	Status *model.ServerStatus
+	Alert  *AlertEvent
+}
+
+// AlertEvent is sent when the state of a rule changes.
+type AlertEvent struct {
+	Rule  string `json:"rule"`
+	State string `json:"state"`
+	// Unix milliseconds of the tick
+	Time int64 `json:"time"`
+	// Such as "cpu: 92.0%", empty if the state is inactive
+	Value string `json:"value,omitempty"`
+}
+
+// StreamSource is where Stream reads from, such as *runner.Monitor.
+type StreamSource interface {
+	Source
+	// Subscribe returns the events from now on.
+	// The channel is closed if the client is too slow or the source is closed,
+	// cancel should be called when the client is gone.
+	Subscribe() (events <-chan Event, cancel func())
+}
+
+// Stream handles `GET /api/v1/stream` with Server-Sent Events.
+// The current status is sent first, then every tick as `status`
+// and every change of rule states as `alert`.
+func Stream(src StreamSource) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		return stream(c, src)
+	}
+}
+
+func stream(c echo.Context, src StreamSource) error {
+	events, cancel := src.Subscribe()
+	defer cancel()
+
+	w := c.Response()
+	w.Header().Set(echo.HeaderContentType, "text/event-stream")
+	w.Header().Set(echo.HeaderCacheControl, "no-cache")
+	w.Header().Set(echo.HeaderConnection, "keep-alive")
+	// Disable the buffering of nginx
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	err := writeEvent(w, "status", newSnapshot(src.Name(), src.Status()))
+	if err != nil {
+		return nil
+	}
+
+	heartbeat := time.NewTicker(res.StreamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-c.Request().Context().Done():
+			return nil
+		case <-heartbeat.C:
+			_, err = fmt.Fprint(w, ": ping\n\n")
+			w.Flush()
+		case event, ok := <-events:
+			if !ok {
+				return nil
+			}
+			switch {
+			case event.Status != nil:
+				err = writeEvent(w, "status", newSnapshot(src.Name(), event.Status))
+			case event.Alert != nil:
+				err = writeEvent(w, "alert", event.Alert)
+			}
+		}
+		if err != nil {
+			// Client is gone
+			return nil
+		}
+	}
+}
+
+func writeEvent(w *echo.Response, name string, data any) error {
+	bytes, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, bytes)
+	if err != nil {
+		return err
+	}
+	w.Flush()
+	return nil
+}
@@ -9,8 +9,20 @@ import (
 	"github.com/lollipopkit/server_box_monitor/model"
 )
 
-func Status(c echo.Context) error {
-	s := model.Status
+// Source is where the handlers read from, such as *runner.Monitor.
+type Source interface {
+	Name() string
+	Status() *model.ServerStatus
+}
+
+func Status(src Source) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		return status(c, src)
+	}
+}
+
+func status(c echo.Context, src Source) error {
+	s := src.Status()
 	cpu := ""
 	if len(s.CPU) > 0 {
 		cpu_, _ := s.CPU[0].UsedPercent()
@@ -46,7 +58,7 @@ func Status(c echo.Context) error {
 		disk = fmt.Sprintf("%s / %s", diskUsed.String(), diskTotal.String())
 	}
 	status := map[string]string{
-		"name": model.Config.Name,
+		"name": src.Name(),
 		"cpu":  cpu,
 		"mem":  mem,
 		"net":  net,